@@ -0,0 +1,264 @@
+package gameplay
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// Policies a computer-controlled player can follow.
+const (
+	PolicyRandom    = "random"    // any legal action
+	PolicyGreedy    = "greedy"    // the move expected to deal the most damage
+	PolicyLookahead = "lookahead" // weighs the opponent's best reply and switches out of bad matchups
+)
+
+const (
+	// lookaheadDiscount weighs next turn's matchup against this turn's damage
+	lookaheadDiscount = 0.5
+	// switchMargin is how much better a switch has to score than attacking,
+	// so the computer doesn't switch back and forth over small differences
+	switchMargin = 0.15
+	// knockoutBonus rewards finishing off the opponent's active Pokémon
+	knockoutBonus = 0.5
+)
+
+// Policy chooses the next action of a computer-controlled player. It gets a
+// random source of its own so its choices don't disturb the battle's rolls,
+// which keeps replays exact.
+type Policy func(battle *Battle, playerID string, rng *rand.Rand) Action
+
+var policies = map[string]Policy{
+	PolicyRandom:    randomPolicy,
+	PolicyGreedy:    greedyPolicy,
+	PolicyLookahead: lookaheadPolicy,
+}
+
+// LookupPolicy finds a computer policy by name.
+func LookupPolicy(name string) (Policy, error) {
+	policy, ok := policies[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown computer policy %s, use %s, %s or %s", name, PolicyRandom, PolicyGreedy, PolicyLookahead)
+	}
+	return policy, nil
+}
+
+// LegalActions lists every action the player could take right now: only
+// replacements after a faint, otherwise every move with PP left, every switch
+// and defending.
+func LegalActions(battle *Battle, playerID string) []Action {
+	player, err := battle.player(playerID)
+	if err != nil {
+		return nil
+	}
+	var actions []Action
+	for i := range player.Pokemon {
+		if checkSwitchTarget(player, i) == nil {
+			actions = append(actions, Action{PlayerID: playerID, Action: "switch", Switch: i})
+		}
+	}
+	if player.MustSwitch {
+		return actions
+	}
+	for i, move := range player.ActivePokemon().Moves {
+		if move.PP > 0 {
+			actions = append(actions, Action{PlayerID: playerID, Action: "attack", Move: i})
+		}
+	}
+	actions = append(actions, Action{PlayerID: playerID, Action: "defend"})
+
+	legal := actions[:0]
+	for _, action := range actions {
+		if validateAction(battle, action) == nil {
+			legal = append(legal, action)
+		}
+	}
+	return legal
+}
+
+// ExpectedDamage estimates the damage a move does on average, counting its
+// accuracy, the damage roll, critical hits, burns and type matchups. Status
+// moves do none.
+func ExpectedDamage(attacker, defender *Pokemon, move *Move) float64 {
+	if move.Category == CategoryStatus || move.Power <= 0 {
+		return 0
+	}
+	attack, defense := offenseAndDefense(attacker, defender, move)
+	damage := float64(baseDamage(attacker.Level, move.Power, attack, defense))
+
+	// Average the critical hit chance and the 85-100% damage roll
+	damage *= 1 + (criticalHitMultiplier-1)/criticalHitOdds
+	damage *= float64(minDamageRoll+100) / 200
+	if attacker.Status == StatusBurn && !move.Special {
+		damage /= 2
+	}
+	damage *= TypeEffectiveness(move.Type, defender)
+	if attacker.HasType(move.Type) {
+		damage *= stabMultiplier
+	}
+	if move.Accuracy > 0 {
+		hit := accuracyPercent(move.Accuracy, attacker, defender)
+		if hit < 100 {
+			damage *= float64(hit) / 100
+		}
+	}
+	return damage
+}
+
+// bestDamage returns the highest expected damage among the attacker's moves
+// with PP left, and the slot of that move (-1 if none deals damage).
+func bestDamage(attacker, defender *Pokemon) (float64, int) {
+	best, slot := 0.0, -1
+	for i := range attacker.Moves {
+		move := &attacker.Moves[i]
+		if move.PP <= 0 {
+			continue
+		}
+		if damage := ExpectedDamage(attacker, defender, move); damage > best {
+			best, slot = damage, i
+		}
+	}
+	return best, slot
+}
+
+// hpFraction is the share of the Pokémon's current HP the damage would take,
+// as a fraction of its max HP.
+func hpFraction(damage float64, pokemon *Pokemon) float64 {
+	if pokemon.MaxHP <= 0 {
+		return 0
+	}
+	if damage > float64(pokemon.HP) {
+		damage = float64(pokemon.HP)
+	}
+	return damage / float64(pokemon.MaxHP)
+}
+
+// matchup scores how well one Pokémon fares against another: the share of
+// the opponent's HP it can take in one hit minus the share it would lose.
+func matchup(pokemon, opponent *Pokemon) float64 {
+	dealt, _ := bestDamage(pokemon, opponent)
+	taken, _ := bestDamage(opponent, pokemon)
+	return hpFraction(dealt, opponent) - hpFraction(taken, pokemon)
+}
+
+// bestReplacement picks the healthy party member with the best matchup
+// against the opponent's active Pokémon.
+func bestReplacement(battle *Battle, player *Player, actions []Action) Action {
+	opponent := battle.opponent(player).ActivePokemon()
+	best, bestScore := actions[0], matchup(&player.Pokemon[actions[0].Switch], opponent)
+	for _, action := range actions[1:] {
+		if score := matchup(&player.Pokemon[action.Switch], opponent); score > bestScore {
+			best, bestScore = action, score
+		}
+	}
+	return best
+}
+
+// splitActions separates switches from the other actions.
+func splitActions(actions []Action) (switches, others []Action) {
+	for _, action := range actions {
+		if action.Action == "switch" {
+			switches = append(switches, action)
+		} else {
+			others = append(others, action)
+		}
+	}
+	return switches, others
+}
+
+// randomPolicy picks any legal action.
+func randomPolicy(battle *Battle, playerID string, rng *rand.Rand) Action {
+	actions := LegalActions(battle, playerID)
+	if len(actions) == 0 {
+		return DefaultAction(battle, playerID)
+	}
+	return actions[rng.Intn(len(actions))]
+}
+
+// greedyPolicy uses the move expected to deal the most damage right now,
+// replacing a fainted Pokémon with the one that hits hardest.
+func greedyPolicy(battle *Battle, playerID string, rng *rand.Rand) Action {
+	actions := LegalActions(battle, playerID)
+	if len(actions) == 0 {
+		return DefaultAction(battle, playerID)
+	}
+	player, _ := battle.player(playerID)
+	opponent := battle.opponent(player).ActivePokemon()
+	switches, others := splitActions(actions)
+
+	if player.MustSwitch {
+		best, bestDealt := switches[0], -1.0
+		for _, action := range switches {
+			if dealt, _ := bestDamage(&player.Pokemon[action.Switch], opponent); dealt > bestDealt {
+				best, bestDealt = action, dealt
+			}
+		}
+		return best
+	}
+
+	// Fall back to a random non-switch action when no move does damage
+	if _, slot := bestDamage(player.ActivePokemon(), opponent); slot >= 0 {
+		return Action{PlayerID: playerID, Action: "attack", Move: slot}
+	}
+	return others[rng.Intn(len(others))]
+}
+
+// lookaheadPolicy scores every action by the damage it deals this turn
+// against the damage the opponent's best reply deals back, and switches to a
+// party member with a better type matchup when staying in looks bad.
+func lookaheadPolicy(battle *Battle, playerID string, rng *rand.Rand) Action {
+	actions := LegalActions(battle, playerID)
+	if len(actions) == 0 {
+		return DefaultAction(battle, playerID)
+	}
+	player, _ := battle.player(playerID)
+	switches, others := splitActions(actions)
+	if player.MustSwitch {
+		return bestReplacement(battle, player, switches)
+	}
+
+	active := player.ActivePokemon()
+	opponent := battle.opponent(player).ActivePokemon()
+	reply, replySlot := bestDamage(opponent, active)
+	taken := hpFraction(reply, active)
+
+	// In simultaneous rounds a slower Pokémon knocked out by the reply never
+	// gets to attack
+	outsped := false
+	if battle.Mode == ModeSimultaneous && replySlot >= 0 && reply >= float64(active.HP) {
+		outsped = opponent.Moves[replySlot].Priority > 0 || opponent.EffectiveSpeed() > active.EffectiveSpeed()
+	}
+
+	best, bestScore := others[rng.Intn(len(others))], -taken
+	for _, action := range others {
+		if action.Action != "attack" {
+			continue
+		}
+		damage := ExpectedDamage(active, opponent, &active.Moves[action.Move])
+		if damage == 0 {
+			continue
+		}
+		score := hpFraction(damage, opponent) - taken
+		if outsped && active.Moves[action.Move].Priority <= opponent.Moves[replySlot].Priority {
+			score = -taken
+		}
+		if damage >= float64(opponent.HP) {
+			score += knockoutBonus
+		}
+		if score > bestScore {
+			best, bestScore = action, score
+		}
+	}
+
+	// Switching takes the reply on the incoming Pokémon, then plays next
+	// turn's matchup
+	for _, action := range switches {
+		incoming := &player.Pokemon[action.Switch]
+		switchTaken, _ := bestDamage(opponent, incoming)
+		score := -hpFraction(switchTaken, incoming) + lookaheadDiscount*matchup(incoming, opponent)
+		if score > bestScore+switchMargin {
+			best, bestScore = action, score
+		}
+	}
+	return best
+}
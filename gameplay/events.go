@@ -0,0 +1,118 @@
+package gameplay
+
+// Kinds of battle event, in the order a client would narrate them.
+const (
+	EventMove          = "move"          // a Pokémon used a move or defended
+	EventMiss          = "miss"          // the move missed its target
+	EventFail          = "fail"          // the move had nothing to do
+	EventPrevented     = "prevented"     // sleep, freeze or paralysis stopped a Pokémon moving
+	EventDamage        = "damage"        // a Pokémon lost HP to a move or its status
+	EventCritical      = "critical"      // the move landed a critical hit
+	EventEffectiveness = "effectiveness" // the move was super effective, not very effective or had no effect
+	EventStatus        = "status"        // a Pokémon was given a status
+	EventCured         = "cured"         // a Pokémon woke up or thawed out
+	EventStatChange    = "stat_change"   // a Pokémon's stat stage rose or fell
+	EventFaint         = "faint"         // a Pokémon fainted
+	EventExperience    = "experience"    // a Pokémon earned experience, and maybe levels
+	EventSwitch        = "switch"        // a player sent out another Pokémon
+	EventForfeit       = "forfeit"       // a player gave up
+	EventWin           = "win"           // a player won the battle
+)
+
+// Values of an effectiveness event.
+const (
+	SuperEffective   = "super_effective"
+	NotVeryEffective = "not_very_effective"
+	NoEffect         = "no_effect"
+)
+
+// Event is one thing that happened in the battle. Only the fields that matter
+// for its kind are set.
+type Event struct {
+	Kind          string   `json:"kind"`
+	PlayerID      string   `json:"player_id,omitempty"` // the player the event is about
+	Pokemon       string   `json:"pokemon,omitempty"`
+	Target        string   `json:"target,omitempty"`
+	Move          string   `json:"move,omitempty"`
+	Damage        int      `json:"damage,omitempty"`         // HP lost, shown only to the Pokémon's trainer
+	DamagePercent int      `json:"damage_percent,omitempty"` // the share of its max HP lost, as its HP bar shows
+	Effectiveness string   `json:"effectiveness,omitempty"`
+	Multiplier    *float64 `json:"multiplier,omitempty"` // the type effectiveness multiplier, such as 4 or 0.5
+	Status        string   `json:"status,omitempty"`
+	Stat          string   `json:"stat,omitempty"`
+	Change        int      `json:"change,omitempty"`
+	Experience    int      `json:"experience,omitempty"`
+	Level         int      `json:"level,omitempty"`     // the new level, when a Pokémon grew
+	Withdrawn     string   `json:"withdrawn,omitempty"` // the Pokémon a switch brought back
+}
+
+// TurnLog is everything that happened in one turn or round, including the
+// replacements sent out after it.
+type TurnLog struct {
+	Turn   int     `json:"turn"`
+	Events []Event `json:"events"`
+}
+
+// ownerOf returns the ID of the player whose team the Pokémon is on.
+func (battle *Battle) ownerOf(pokemon *Pokemon) string {
+	for _, player := range []*Player{&battle.Player1, &battle.Player2} {
+		for i := range player.Pokemon {
+			if &player.Pokemon[i] == pokemon {
+				return player.ID
+			}
+		}
+	}
+	return ""
+}
+
+// event starts an event about one of the battle's Pokémon.
+func (battle *Battle) event(kind string, pokemon *Pokemon) Event {
+	return Event{Kind: kind, PlayerID: battle.ownerOf(pokemon), Pokemon: pokemon.Name}
+}
+
+// damageEvent reports the HP a Pokémon lost since it had hpBefore.
+func (battle *Battle) damageEvent(pokemon *Pokemon, hpBefore int) Event {
+	event := battle.event(EventDamage, pokemon)
+	event.Damage = hpBefore - pokemon.HP
+	event.DamagePercent = max(1, hpShare(hpBefore, pokemon.MaxHP)-hpPercent(pokemon))
+	return event
+}
+
+func (battle *Battle) emit(event Event) {
+	battle.Events = append(battle.Events, event)
+}
+
+// effectivenessEvent describes a type effectiveness multiplier, or returns
+// false for a neutral hit.
+func effectivenessEvent(effectiveness float64) (string, bool) {
+	switch {
+	case effectiveness == 0:
+		return NoEffect, true
+	case effectiveness > 1:
+		return SuperEffective, true
+	case effectiveness < 1:
+		return NotVeryEffective, true
+	}
+	return "", false
+}
+
+// logEvents runs an input against the battle, leaving Events holding only
+// what it caused and adding them to the history under the turn. If the input
+// fails, the previous events are kept.
+func (battle *Battle) logEvents(turn int, input func() error) error {
+	previous := battle.Events
+	battle.Events = nil
+	if err := input(); err != nil {
+		battle.Events = previous
+		return err
+	}
+	if len(battle.Events) == 0 {
+		return nil
+	}
+	if last := len(battle.History) - 1; last >= 0 && battle.History[last].Turn == turn {
+		battle.History[last].Events = append(battle.History[last].Events, battle.Events...)
+		return nil
+	}
+	battle.History = append(battle.History, TurnLog{Turn: turn, Events: append([]Event(nil), battle.Events...)})
+	return nil
+}
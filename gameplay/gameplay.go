@@ -5,53 +5,76 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 )
 
-type Move struct {
-	Name       string
-	Damage     int
-	Special    bool
-}
-
 type Pokemon struct {
-	Name          string `json:"name"`
-	Height        int    `json:"height"`
-	Weight        int    `json:"weight"`
-	HP            int
-	Attack        int
-	Special       int
-	Speed         int
-	Stats         []struct {
+	Name   string `json:"name"`
+	Height int    `json:"height"`
+	Weight int    `json:"weight"`
+	// Battle stats, calculated from the base stats in the data files
+	Level          int     `json:"level"`
+	Experience     int     `json:"experience"`
+	LevelsGained   int     `json:"levels_gained,omitempty"` // in this battle
+	BaseExperience int     `json:"base_experience"`
+	Nature         string  `json:"nature"`
+	IVs            StatSet `json:"ivs"`
+	EVs            StatSet `json:"evs"`
+	HP             int     `json:"hp"`
+	Attack         int     `json:"attack"`
+	Defense        int     `json:"defense"`
+	SpecialAttack  int     `json:"special_attack"`
+	SpecialDefense int     `json:"special_defense"`
+	Speed          int     `json:"speed"`
+	MaxHP          int     `json:"max_hp"`
+	BaseStats      StatSet `json:"base_stats"`
+	Stats          []struct {
 		Stat struct {
 			Name string `json:"name"`
 		} `json:"stat"`
 		BaseStat int `json:"base_stat"`
 	} `json:"stats"`
-	Types         []struct {
+	Types []struct {
 		Type struct {
 			Name string `json:"name"`
 		} `json:"type"`
 	} `json:"types"`
-	Abilities     []struct {
+	Abilities []struct {
 		Ability struct {
 			Name string `json:"name"`
 		} `json:"ability"`
 	} `json:"abilities"`
-	DefenseBoost  int
+	Moves      []Move     `json:"moves"`
+	Status     string     `json:"status"`
+	SleepTurns int        `json:"sleep_turns,omitempty"`
+	Stages     StatStages `json:"stages"`
 }
 
 type Player struct {
-	ID                  string     `json:"id"`
-	Name                string     `json:"name"`
-	Pokemon             []Pokemon  `json:"pokemon"`
-	CurrentPokemonIndex int        `json:"current_pokemon_index"`
+	ID                  string    `json:"id"`
+	Name                string    `json:"name"`
+	Pokemon             []Pokemon `json:"pokemon"`
+	CurrentPokemonIndex int       `json:"current_pokemon_index"`
+	MustSwitch          bool      `json:"must_switch"`
 }
 
 type Battle struct {
-	Player1 Player `json:"player1"`
-	Player2 Player `json:"player2"`
-	Turn     int    `json:"turn"`
+	ID             string            `json:"id"`
+	Player1        Player            `json:"player1"`
+	Player2        Player            `json:"player2"`
+	Turn           int               `json:"turn"`
+	Mode           string            `json:"mode"`
+	Status         string            `json:"status"`
+	Winner         string            `json:"winner,omitempty"`
+	TurnTimeLeft   int               `json:"turn_time_left,omitempty"` // seconds left to choose, when timed
+	Seed           int64             `json:"seed"`
+	Events         []Event           `json:"events,omitempty"` // what the latest action or round caused
+	History        []TurnLog         `json:"-"`
+	PendingActions map[string]Action `json:"-"`
+
+	rng    *rand.Rand
+	replay *Replay
 }
 
 func ReadPokemonData(number string) (Pokemon, error) {
@@ -90,23 +113,98 @@ func ReadPokemonData(number string) (Pokemon, error) {
 		Types:     rawPokemon.Types,
 		Abilities: rawPokemon.Abilities,
 	}
+	moves, err := DefaultMoveset(&pokemon)
+	if err != nil {
+		log.Printf("Error: Failed to load moveset for %s: %v", pokemon.Name, err)
+		return Pokemon{}, fmt.Errorf("failed to load moveset for %s: %v", pokemon.Name, err)
+	}
+	pokemon.Moves = moves
+
 	for _, stat := range rawPokemon.Stats {
-		switch stat.Stat.Name {
-		case "hp":
-			pokemon.HP = stat.BaseStat
-		case "attack":
-			pokemon.Attack = stat.BaseStat
-		case "special-attack":
-			pokemon.Special = stat.BaseStat
-		case "speed":
-			pokemon.Speed = stat.BaseStat
-		}
+		pokemon.BaseStats.set(stat.Stat.Name, stat.BaseStat)
 	}
+	pokemon.BaseExperience = estimateBaseExperience(pokemon.BaseStats)
+	pokemon.Level = DefaultLevel
+	pokemon.Experience = ExperienceForLevel(pokemon.Level)
+	pokemon.IVs = perfectIVs()
+	pokemon.Nature = DefaultNature
+	pokemon.RecalculateStats()
 
-	log.Printf("Successfully read data for Pokémon %s (HP: %d, Attack: %d, Special: %d, Speed: %d)", pokemon.Name, pokemon.HP, pokemon.Attack, pokemon.Special, pokemon.Speed)
+	log.Printf("Successfully read data for Pokémon %s (Level: %d, HP: %d, Attack: %d, Defense: %d, Sp. Atk: %d, Sp. Def: %d, Speed: %d)", pokemon.Name, pokemon.Level, pokemon.HP, pokemon.Attack, pokemon.Defense, pokemon.SpecialAttack, pokemon.SpecialDefense, pokemon.Speed)
 	return pokemon, nil
 }
 
+// checkFainted makes the player choose a replacement if their active Pokémon
+// fainted and they have another one left.
+func checkFainted(battle *Battle, player *Player) {
+	if player.ActivePokemon().HP > 0 {
+		return
+	}
+	log.Printf("%s fainted!", player.ActivePokemon().Name)
+	battle.emit(battle.event(EventFaint, player.ActivePokemon()))
+	player.MustSwitch = hasRemainingPokemon(player)
+}
+
+// Battle statuses. A battle without one is treated as active.
+const (
+	BattlePending   = "pending"   // waiting for the second player to join
+	BattleActive    = "active"    // both players are battling
+	BattleFinished  = "finished"  // one player has no Pokémon left
+	BattleForfeited = "forfeited" // one player gave up
+)
+
+// IsOver reports whether the battle has ended, by a knockout or a forfeit.
+func (battle *Battle) IsOver() bool {
+	return battle.Status == BattleFinished || battle.Status == BattleForfeited
+}
+
+// updateStatus ends the battle once one player has no Pokémon left.
+func updateStatus(battle *Battle) {
+	if battle.IsOver() {
+		return
+	}
+	if winner := Winner(battle); winner != "" {
+		battle.Status = BattleFinished
+		battle.Winner = winner
+		battle.emit(Event{Kind: EventWin, PlayerID: winner})
+		log.Printf("Battle %s is over, %s wins!", battle.ID, winner)
+	}
+}
+
+// Forfeit ends the battle with the player giving up and their opponent
+// winning.
+func Forfeit(battle *Battle, playerID string) error {
+	return battle.logEvents(battle.Turn, func() error {
+		if err := checkInProgress(battle); err != nil {
+			return err
+		}
+		player, err := battle.player(playerID)
+		if err != nil {
+			return err
+		}
+		battle.Status = BattleForfeited
+		battle.Winner = battle.opponent(player).ID
+		battle.PendingActions = nil
+		battle.record(InputForfeit, Action{PlayerID: playerID, Action: "forfeit"})
+		battle.emit(Event{Kind: EventForfeit, PlayerID: playerID})
+		battle.emit(Event{Kind: EventWin, PlayerID: battle.Winner})
+		log.Printf("%s forfeited battle %s", player.Name, battle.ID)
+		return nil
+	})
+}
+
+// Winner returns the ID of the player who won the battle, or an empty string
+// while both players still have Pokémon left.
+func Winner(battle *Battle) string {
+	switch {
+	case !hasRemainingPokemon(&battle.Player2):
+		return battle.Player1.ID
+	case !hasRemainingPokemon(&battle.Player1):
+		return battle.Player2.ID
+	}
+	return ""
+}
+
 func hasRemainingPokemon(player *Player) bool {
 	for _, pkmn := range player.Pokemon {
 		if pkmn.HP > 0 {
@@ -116,11 +214,7 @@ func hasRemainingPokemon(player *Player) bool {
 	return false
 }
 
-func (pokemon *Pokemon) Defense() int {
-	return pokemon.Weight / 10
-}
-
-func ExecuteAttack(battle *Battle, playerID string) {
+func ExecuteAttack(battle *Battle, playerID string, moveIndex int) error {
 	var attacker *Pokemon
 	var defender *Pokemon
 	var currentPlayer *Player
@@ -138,48 +232,141 @@ func ExecuteAttack(battle *Battle, playerID string) {
 	attacker = &currentPlayer.Pokemon[currentPlayer.CurrentPokemonIndex]
 	defender = &opposingPlayer.Pokemon[opposingPlayer.CurrentPokemonIndex]
 
-	move := Move{Name: "Tackle", Damage: 40, Special: false}
+	if moveIndex < 0 || moveIndex >= len(attacker.Moves) {
+		return fmt.Errorf("%s has no move in slot %d", attacker.Name, moveIndex+1)
+	}
+	move := &attacker.Moves[moveIndex]
+	if move.PP <= 0 {
+		return fmt.Errorf("%s has no PP left", move.Name)
+	}
 
-	var damage int
-	if move.Special {
-		// Special move damage
-		damage = attacker.Special + move.Damage - defender.Defense()
-	} else {
-		// Regular move damage
-		damage = attacker.Attack + move.Damage - defender.Defense()
+	// Sleep, freeze and paralysis can stop the attacker before it moves
+	if checkCanMove(battle, attacker) != StatusNone {
+		return nil
 	}
+	move.PP--
+	moveEvent := battle.event(EventMove, attacker)
+	moveEvent.Target = defender.Name
+	moveEvent.Move = move.Name
+	battle.emit(moveEvent)
 
-	// Apply defense boost if defender has it
-	if defender.DefenseBoost > 0 {
-		damage -= defender.DefenseBoost
-		if damage < 1 {
-			damage = 1
+	// Roll for accuracy; moves with no accuracy never miss
+	if move.Accuracy > 0 && battle.random().Intn(100) >= accuracyPercent(move.Accuracy, attacker, defender) {
+		log.Printf("%s used %s, but it missed!", attacker.Name, move.Name)
+		miss := battle.event(EventMiss, attacker)
+		miss.Move = move.Name
+		battle.emit(miss)
+		return nil
+	}
+
+	// Status moves only inflict their condition or change stat stages. Ones
+	// aimed at the target fail against a type they can't affect, such as
+	// Thunder Wave against a Ground type.
+	if move.Category == CategoryStatus {
+		immune := move.StatTarget != "user" && TypeEffectiveness(move.Type, defender) == 0
+		inflicted := !immune && move.Ailment != StatusNone && inflictStatus(battle, defender, move.Ailment)
+		var changes []StageChange
+		if !immune {
+			changes = applyMoveStatChanges(battle, attacker, defender, move)
+		}
+		if !inflicted && len(changes) == 0 {
+			log.Printf("%s used %s, but it failed!", attacker.Name, move.Name)
+			fail := battle.event(EventFail, attacker)
+			fail.Move = move.Name
+			battle.emit(fail)
 		}
-		defender.DefenseBoost = 0
+		return nil
+	}
+
+	// Physical moves use attack vs defense, special moves special attack vs special defense
+	attack, defense := offenseAndDefense(attacker, defender, move)
+	damage := baseDamage(attacker.Level, move.Power, attack, defense)
+
+	// Critical hits and the damage roll come from the battle's random source
+	critical := rollCriticalHit(battle)
+	if critical {
+		damage = int(float64(damage) * criticalHitMultiplier)
+		log.Printf("A critical hit!")
+	}
+	damage = rollDamage(battle, damage)
+
+	// Burns halve the damage of physical moves
+	if attacker.Status == StatusBurn && !move.Special {
+		damage /= 2
+	}
+
+	// Scale by same-type attack bonus and type effectiveness
+	effectiveness := TypeEffectiveness(move.Type, defender)
+	stab := attacker.HasType(move.Type)
+	multiplier := effectiveness
+	if stab {
+		multiplier *= stabMultiplier
+	}
+	damage = int(float64(damage) * multiplier)
+	if effectiveness == 0 {
+		damage = 0
+	} else if damage < 1 {
+		damage = 1
 	}
 
 	// Apply damage to the defender's HP
+	hpBefore := defender.HP
 	defender.HP -= damage
 	if defender.HP < 0 {
 		defender.HP = 0
 	}
 
 	log.Printf("%s attacked %s with %s, causing %d damage!", attacker.Name, defender.Name, move.Name, damage)
+	if damage > 0 {
+		hit := battle.damageEvent(defender, hpBefore)
+		hit.Move = move.Name
+		battle.emit(hit)
+	}
+	if critical && damage > 0 {
+		battle.emit(battle.event(EventCritical, defender))
+	}
+	if effect, ok := effectivenessEvent(effectiveness); ok {
+		log.Print(EffectivenessMessage(effectiveness))
+		event := battle.event(EventEffectiveness, defender)
+		event.Effectiveness = effect
+		event.Multiplier = &effectiveness
+		battle.emit(event)
+	}
 
-	// If the defender's Pokémon fainted, move to the next one
-	if defender.HP <= 0 {
-		opposingPlayer.CurrentPokemonIndex++
+	// Fire moves thaw a frozen target; other moves may inflict a status
+	if defender.Status == StatusFreeze && move.Type == "fire" && damage > 0 {
+		defender.Status = StatusNone
+		log.Printf("%s thawed out!", defender.Name)
+		cured := battle.event(EventCured, defender)
+		cured.Status = StatusFreeze
+		battle.emit(cured)
+	}
+	if damage > 0 && move.AilmentChance > 0 && battle.random().Intn(100) < move.AilmentChance {
+		inflictStatus(battle, defender, move.Ailment)
+	}
+	if damage > 0 && len(move.StatChanges) > 0 && (move.StatChance == 0 || battle.random().Intn(100) < move.StatChance) {
+		applyMoveStatChanges(battle, attacker, defender, move)
 	}
 
-	// Check if the opposing player has any remaining Pokémon
-	if !hasRemainingPokemon(opposingPlayer) {
-		log.Printf("%s has no remaining Pokémon! %s wins!", opposingPlayer.Name, currentPlayer.Name)
-		// Handle game win condition here
-		return
+	// If the defender's Pokémon fainted, its trainer has to pick a replacement
+	checkFainted(battle, opposingPlayer)
+
+	// Defeating a Pokémon earns the attacker experience
+	if defender.HP <= 0 {
+		experience := experienceYield(defender)
+		levels := attacker.GainExperience(experience)
+		gained := battle.event(EventExperience, attacker)
+		gained.Experience = experience
+		if levels > 0 {
+			gained.Level = attacker.Level
+		}
+		battle.emit(gained)
 	}
+	return nil
 }
 
-// Function to execute the defend action (new)
+// Function to execute the defend action, raising the active Pokémon's
+// defense and special defense by one stage
 func ExecuteDefend(battle *Battle, playerID string) {
 	var defender *Pokemon
 	var currentPlayer *Player
@@ -194,8 +381,12 @@ func ExecuteDefend(battle *Battle, playerID string) {
 	// Get the current Pokémon for both players
 	defender = &currentPlayer.Pokemon[currentPlayer.CurrentPokemonIndex]
 
-	// Increase the defender's defense boost for the next attack
-	defender.DefenseBoost += 100
-
-	log.Printf("%s chose to defend! %s's defense will be boosted on the next attack.", defender.Name, defender.Name)
-}
\ No newline at end of file
+	log.Printf("%s chose to defend!", defender.Name)
+	defend := battle.event(EventMove, defender)
+	defend.Move = "Defend"
+	battle.emit(defend)
+	applyStatChanges(battle, defender, []StatChange{
+		{Stat: StatDefense, Change: 1},
+		{Stat: StatSpecialDefense, Change: 1},
+	})
+}
@@ -0,0 +1,186 @@
+package gameplay
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	moveDataFile     = "../monsterData/move_data/moves.json"
+	learnsetDataFile = "../monsterData/move_data/learnsets.json"
+
+	// MaxMoves is the number of moves a Pokémon can know at once.
+	MaxMoves = 4
+)
+
+// Move categories as stored in the move catalogue.
+const (
+	CategoryPhysical = "physical"
+	CategorySpecial  = "special"
+	CategoryStatus   = "status"
+)
+
+type Move struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Power    int    `json:"power"`
+	Accuracy int    `json:"accuracy"` // 0 means the move never misses
+	PP       int    `json:"pp"`
+	MaxPP    int    `json:"max_pp"`
+	Category string `json:"category"`
+	Priority int    `json:"priority"`
+	Special  bool   `json:"special"`
+
+	// Ailment is the status the move can inflict. Status moves always try to
+	// inflict it, damaging moves do so with AilmentChance percent.
+	Ailment       string `json:"ailment,omitempty"`
+	AilmentChance int    `json:"ailment_chance,omitempty"`
+
+	// StatChanges are stage changes made to StatTarget ("user" or "target").
+	// Damaging moves make them with StatChance percent, or always when it is 0.
+	StatChanges []StatChange `json:"stat_changes,omitempty"`
+	StatTarget  string       `json:"stat_target,omitempty"`
+	StatChance  int          `json:"stat_chance,omitempty"`
+}
+
+var (
+	moveDataOnce sync.Once
+	moveDataErr  error
+	moveDex      map[string]Move
+	learnsets    map[string][]string
+)
+
+// loadMoveData reads the move catalogue and learnsets once per process.
+func loadMoveData() error {
+	moveDataOnce.Do(func() {
+		if moveDataErr = readJSONFile(moveDataFile, &moveDex); moveDataErr != nil {
+			return
+		}
+		for slug, move := range moveDex {
+			move.MaxPP = move.PP
+			move.Special = move.Category == CategorySpecial
+			moveDex[slug] = move
+		}
+		moveDataErr = readJSONFile(learnsetDataFile, &learnsets)
+		if moveDataErr == nil {
+			log.Printf("Loaded %d moves and %d learnsets", len(moveDex), len(learnsets))
+		}
+	})
+	return moveDataErr
+}
+
+func readJSONFile(filename string, v interface{}) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %v", filename, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to unmarshal JSON for file %s: %v", filename, err)
+	}
+	return nil
+}
+
+// moveSlug normalises a move name such as "Thunder Shock" to its catalogue
+// key "thunder-shock".
+func moveSlug(name string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "-")
+}
+
+// LookupMove returns a fresh copy of a move from the catalogue with full PP.
+func LookupMove(name string) (Move, error) {
+	if err := loadMoveData(); err != nil {
+		return Move{}, err
+	}
+	move, ok := moveDex[moveSlug(name)]
+	if !ok {
+		return Move{}, fmt.Errorf("unknown move %s", name)
+	}
+	return move, nil
+}
+
+// Learnset returns the catalogue keys of every move the species can learn.
+func Learnset(species string) ([]string, error) {
+	if err := loadMoveData(); err != nil {
+		return nil, err
+	}
+	learnset, ok := learnsets[strings.ToLower(species)]
+	if !ok {
+		return nil, fmt.Errorf("no learnset for %s", species)
+	}
+	return learnset, nil
+}
+
+// SetMoves replaces the Pokémon's moves with the named moves from its learnset.
+func (pokemon *Pokemon) SetMoves(names []string) error {
+	learnset, err := Learnset(pokemon.Name)
+	if err != nil {
+		return err
+	}
+	learnable := make(map[string]bool, len(learnset))
+	for _, slug := range learnset {
+		learnable[slug] = true
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		slug := moveSlug(name)
+		if !learnable[slug] {
+			return fmt.Errorf("%s can't learn %s", pokemon.Name, name)
+		}
+		if seen[slug] {
+			return fmt.Errorf("%s can't know %s twice", pokemon.Name, name)
+		}
+		seen[slug] = true
+	}
+	moves, err := buildMoveset(names)
+	if err != nil {
+		return err
+	}
+	pokemon.Moves = moves
+	return nil
+}
+
+// DefaultMoveset returns the last four moves of the Pokémon's learnset, the
+// same moves a wild Pokémon would know, except that its strongest damaging
+// move of its own type always comes last so every default team has one.
+func DefaultMoveset(pokemon *Pokemon) ([]Move, error) {
+	learnset, err := Learnset(pokemon.Name)
+	if err != nil {
+		return nil, err
+	}
+	moves, err := buildMoveset(learnset)
+	if err != nil {
+		return nil, err
+	}
+
+	strongest := -1
+	for i, move := range moves {
+		if move.Category != CategoryStatus && pokemon.HasType(move.Type) && (strongest < 0 || move.Power > moves[strongest].Power) {
+			strongest = i
+		}
+	}
+	if strongest >= 0 {
+		move := moves[strongest]
+		moves = append(slices.Delete(moves, strongest, strongest+1), move)
+	}
+	if len(moves) > MaxMoves {
+		moves = moves[len(moves)-MaxMoves:]
+	}
+	return moves, nil
+}
+
+func buildMoveset(names []string) ([]Move, error) {
+	moves := make([]Move, 0, len(names))
+	for _, name := range names {
+		move, err := LookupMove(name)
+		if err != nil {
+			return nil, err
+		}
+		moves = append(moves, move)
+	}
+	return moves, nil
+}
@@ -0,0 +1,142 @@
+package gameplay
+
+import (
+	"log"
+	"strings"
+	"sync"
+)
+
+const evolutionDataFile = "../monsterData/evolution_data/evolutions.json"
+
+// Evolution is the level-up evolution of a species.
+type Evolution struct {
+	EvolvesTo string `json:"evolves_to"`
+	MinLevel  int    `json:"min_level"`
+}
+
+// TrainedPokemon is the part of a Pokémon that carries over from one battle
+// to the next.
+type TrainedPokemon struct {
+	Species    string   `json:"species"`
+	Level      int      `json:"level"`
+	Experience int      `json:"experience"`
+	IVs        StatSet  `json:"ivs"`
+	EVs        StatSet  `json:"evs"`
+	Nature     string   `json:"nature"`
+	Moves      []string `json:"moves"`
+}
+
+var (
+	evolutionOnce sync.Once
+	evolutionErr  error
+	evolutions    map[string]Evolution
+)
+
+func loadEvolutions() error {
+	evolutionOnce.Do(func() {
+		evolutionErr = readJSONFile(evolutionDataFile, &evolutions)
+	})
+	return evolutionErr
+}
+
+// ExperienceForLevel is the total experience needed to reach a level. Every
+// species uses the medium-fast growth rate.
+func ExperienceForLevel(level int) int {
+	return level * level * level
+}
+
+// experienceYield is the experience gained for defeating a Pokémon in a
+// trainer battle.
+func experienceYield(defeated *Pokemon) int {
+	return defeated.BaseExperience * defeated.Level * 3 / 14
+}
+
+// estimateBaseExperience approximates a species' base experience yield from
+// its base stat total, as the data files don't include one.
+func estimateBaseExperience(base StatSet) int {
+	total := base.total()
+	return total * total / 1200
+}
+
+// GainExperience adds experience and levels the Pokémon up as far as it
+// goes, recalculating its stats. It returns the number of levels gained and
+// adds them to the levels the Pokémon has gained in the battle.
+func (pokemon *Pokemon) GainExperience(amount int) int {
+	pokemon.Experience += amount
+	levels := 0
+	for pokemon.Level < MaxLevel && pokemon.Experience >= ExperienceForLevel(pokemon.Level+1) {
+		pokemon.Level++
+		levels++
+	}
+	pokemon.LevelsGained += levels
+	if levels > 0 {
+		pokemon.RecalculateStats()
+		log.Printf("%s grew to level %d!", pokemon.Name, pokemon.Level)
+	}
+	return levels
+}
+
+// Trained returns the progress a Pokémon keeps after the battle.
+func (pokemon *Pokemon) Trained() TrainedPokemon {
+	moves := make([]string, 0, len(pokemon.Moves))
+	for _, move := range pokemon.Moves {
+		moves = append(moves, moveSlug(move.Name))
+	}
+	return TrainedPokemon{
+		Species:    pokemon.Name,
+		Level:      pokemon.Level,
+		Experience: pokemon.Experience,
+		IVs:        pokemon.IVs,
+		EVs:        pokemon.EVs,
+		Nature:     pokemon.Nature,
+		Moves:      moves,
+	}
+}
+
+// Options returns the options to raise the trained Pokémon for a new battle.
+func (trained *TrainedPokemon) Options() PokemonOptions {
+	ivs := trained.IVs
+	return PokemonOptions{
+		Level:  trained.Level,
+		IVs:    &ivs,
+		EVs:    trained.EVs,
+		Nature: trained.Nature,
+		Moves:  trained.Moves,
+	}
+}
+
+// Evolve turns the trained Pokémon into its evolution if it leveled up in
+// the battle and is at the level for it, dropping any moves the evolved
+// species can't learn. It reports whether the Pokémon evolved.
+func Evolve(trained *TrainedPokemon, levelsGained int) (bool, error) {
+	if levelsGained == 0 {
+		return false, nil
+	}
+	if err := loadEvolutions(); err != nil {
+		return false, err
+	}
+	evolution, ok := evolutions[strings.ToLower(trained.Species)]
+	if !ok || trained.Level < evolution.MinLevel {
+		return false, nil
+	}
+
+	learnset, err := Learnset(evolution.EvolvesTo)
+	if err != nil {
+		return false, err
+	}
+	learnable := make(map[string]bool, len(learnset))
+	for _, slug := range learnset {
+		learnable[slug] = true
+	}
+	var moves []string
+	for _, move := range trained.Moves {
+		if learnable[move] {
+			moves = append(moves, move)
+		}
+	}
+
+	log.Printf("%s evolved into %s!", trained.Species, evolution.EvolvesTo)
+	trained.Species = evolution.EvolvesTo
+	trained.Moves = moves
+	return true, nil
+}
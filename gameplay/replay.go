@@ -0,0 +1,130 @@
+package gameplay
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Kinds of input recorded in a battle's replay log.
+const (
+	InputTurn    = "turn"    // an action on the player's turn, after which the turn passes
+	InputSubmit  = "submit"  // a choice for a simultaneous round
+	InputReplace = "replace" // a replacement sent in after a faint
+	InputForfeit = "forfeit" // a player giving up
+)
+
+// ReplayInput is one input a battle received, in the order it was applied.
+type ReplayInput struct {
+	Kind   string `json:"kind"`
+	Action Action `json:"action"`
+}
+
+// Replay is everything needed to re-simulate a battle exactly: the teams as
+// they were at the start, the seed of its random source and every input.
+type Replay struct {
+	BattleID string        `json:"battle_id"`
+	Mode     string        `json:"mode"`
+	Seed     int64         `json:"seed"`
+	Player1  Player        `json:"player1"`
+	Player2  Player        `json:"player2"`
+	Inputs   []ReplayInput `json:"inputs"`
+}
+
+// clonePlayer makes a deep copy of a player and their team.
+func clonePlayer(player Player) Player {
+	var clone Player
+	data, err := json.Marshal(player)
+	if err == nil {
+		err = json.Unmarshal(data, &clone)
+	}
+	if err != nil {
+		log.Printf("Failed to copy %s for the replay: %v", player.Name, err)
+	}
+	return clone
+}
+
+// StartBattle begins a battle once both players have their teams, recording
+// the teams as the start of the replay.
+func StartBattle(battle *Battle) {
+	if battle.Turn == 0 {
+		battle.Turn = 1
+	}
+	battle.Status = BattleActive
+	battle.replay = &Replay{
+		BattleID: battle.ID,
+		Mode:     battle.Mode,
+		Seed:     battle.Seed,
+		Player1:  clonePlayer(battle.Player1),
+		Player2:  clonePlayer(battle.Player2),
+	}
+}
+
+// record adds an input that was applied to the battle to its replay log.
+func (battle *Battle) record(kind string, action Action) {
+	if battle.replay != nil {
+		battle.replay.Inputs = append(battle.replay.Inputs, ReplayInput{Kind: kind, Action: action})
+	}
+}
+
+// Replay returns a copy of the battle's replay log.
+func (battle *Battle) Replay() Replay {
+	if battle.replay == nil {
+		return Replay{BattleID: battle.ID, Mode: battle.Mode, Seed: battle.Seed}
+	}
+	replay := *battle.replay
+	replay.Inputs = append([]ReplayInput(nil), battle.replay.Inputs...)
+	return replay
+}
+
+// PlayTurn carries out the action of the player whose turn it is and passes
+// the turn.
+func PlayTurn(battle *Battle, action Action) error {
+	action.Action = strings.ToLower(action.Action)
+	return battle.logEvents(battle.Turn, func() error {
+		if err := ExecuteAction(battle, action); err != nil {
+			return err
+		}
+		battle.record(InputTurn, action)
+		FinishTurn(battle, action.PlayerID)
+		return nil
+	})
+}
+
+// ReplayBattle re-simulates a battle from its replay. Because every random
+// roll comes from the seeded source, the result matches the original battle
+// exactly.
+func ReplayBattle(replay Replay) (*Battle, error) {
+	battle := &Battle{
+		ID:      replay.BattleID,
+		Player1: clonePlayer(replay.Player1),
+		Player2: clonePlayer(replay.Player2),
+		Mode:    replay.Mode,
+		Seed:    replay.Seed,
+	}
+	StartBattle(battle)
+
+	for i, input := range replay.Inputs {
+		var err error
+		switch input.Kind {
+		case InputTurn:
+			err = PlayTurn(battle, input.Action)
+		case InputSubmit:
+			var ready bool
+			if ready, err = SubmitAction(battle, input.Action); err == nil && ready {
+				err = ResolveRound(battle)
+			}
+		case InputReplace:
+			err = ReplaceFainted(battle, input.Action.PlayerID, input.Action.Switch)
+		case InputForfeit:
+			err = Forfeit(battle, input.Action.PlayerID)
+		default:
+			err = fmt.Errorf("unknown input kind %s", input.Kind)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("replay input %d: %v", i+1, err)
+		}
+	}
+	return battle, nil
+}
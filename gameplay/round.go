@@ -0,0 +1,286 @@
+package gameplay
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Battle modes: players either alternate turns or both pick an action each
+// round and the server resolves them together.
+const (
+	ModeTurns        = "turns"
+	ModeSimultaneous = "simultaneous"
+)
+
+// Action is a single player's choice for a turn or round.
+type Action struct {
+	PlayerID string `json:"player_id"`
+	Action   string `json:"action"`
+	Move     int    `json:"move"`
+	Switch   int    `json:"switch"`
+}
+
+// random returns the battle's random source, seeding it on first use.
+func (battle *Battle) random() *rand.Rand {
+	if battle.rng == nil {
+		battle.rng = rand.New(rand.NewSource(battle.Seed))
+	}
+	return battle.rng
+}
+
+func (battle *Battle) player(playerID string) (*Player, error) {
+	switch playerID {
+	case battle.Player1.ID:
+		return &battle.Player1, nil
+	case battle.Player2.ID:
+		return &battle.Player2, nil
+	}
+	return nil, fmt.Errorf("unknown player %s", playerID)
+}
+
+func (battle *Battle) opponent(player *Player) *Player {
+	if player == &battle.Player1 {
+		return &battle.Player2
+	}
+	return &battle.Player1
+}
+
+// ActivePokemon returns the player's Pokémon currently in battle.
+func (player *Player) ActivePokemon() *Pokemon {
+	return &player.Pokemon[player.CurrentPokemonIndex]
+}
+
+// EffectiveSpeed is the speed used to order actions within a round.
+func (pokemon *Pokemon) EffectiveSpeed() int {
+	speed := applyStage(pokemon.Speed, pokemon.Stages.Speed)
+	if pokemon.Status == StatusParalysis {
+		return speed / 2
+	}
+	return speed
+}
+
+// checkInProgress rejects anything done to a battle that hasn't started or
+// has already ended.
+func checkInProgress(battle *Battle) error {
+	switch {
+	case battle.Status == BattlePending:
+		return fmt.Errorf("the battle hasn't started yet")
+	case battle.IsOver():
+		return fmt.Errorf("the battle is over")
+	}
+	return nil
+}
+
+// validateAction checks an action can be carried out without changing the battle.
+func validateAction(battle *Battle, action Action) error {
+	if err := checkInProgress(battle); err != nil {
+		return err
+	}
+	player, err := battle.player(action.PlayerID)
+	if err != nil {
+		return err
+	}
+	if !hasRemainingPokemon(player) {
+		return fmt.Errorf("%s has no Pokémon left to battle", player.Name)
+	}
+	if waiting := pendingReplacement(battle); waiting != nil {
+		return fmt.Errorf("waiting for %s to send out a replacement", waiting.Name)
+	}
+	switch action.Action {
+	case "attack":
+		attacker := player.ActivePokemon()
+		if action.Move < 0 || action.Move >= len(attacker.Moves) {
+			return fmt.Errorf("%s has no move in slot %d", attacker.Name, action.Move+1)
+		}
+		if attacker.Moves[action.Move].PP <= 0 {
+			return fmt.Errorf("%s has no PP left", attacker.Moves[action.Move].Name)
+		}
+	case "switch":
+		return checkSwitchTarget(player, action.Switch)
+	case "defend":
+	default:
+		return fmt.Errorf("invalid action %s", action.Action)
+	}
+	return nil
+}
+
+// ExecuteAction carries out a single action immediately.
+func ExecuteAction(battle *Battle, action Action) error {
+	action.Action = strings.ToLower(action.Action)
+	if err := validateAction(battle, action); err != nil {
+		return err
+	}
+	var err error
+	switch action.Action {
+	case "attack":
+		err = ExecuteAttack(battle, action.PlayerID, action.Move)
+	case "switch":
+		err = ExecuteSwitch(battle, action.PlayerID, action.Switch)
+	default:
+		ExecuteDefend(battle, action.PlayerID)
+	}
+	updateStatus(battle)
+	return err
+}
+
+// DefaultAction is the action taken for a player who runs out of time: the
+// first healthy replacement after a faint, otherwise the move expected to deal
+// the most damage, otherwise any move with PP left. Once the active Pokémon
+// has no PP left it switches to a party member that has, and forfeits when
+// none has.
+func DefaultAction(battle *Battle, playerID string) Action {
+	action := Action{PlayerID: playerID, Action: "defend"}
+	player, err := battle.player(playerID)
+	if err != nil {
+		return action
+	}
+	if player.MustSwitch {
+		for i := range player.Pokemon {
+			if checkSwitchTarget(player, i) == nil {
+				return Action{PlayerID: playerID, Action: "switch", Switch: i}
+			}
+		}
+		return action
+	}
+
+	active := player.ActivePokemon()
+	if _, slot := bestDamage(active, battle.opponent(player).ActivePokemon()); slot >= 0 {
+		return Action{PlayerID: playerID, Action: "attack", Move: slot}
+	}
+	for i, move := range active.Moves {
+		if move.PP > 0 {
+			return Action{PlayerID: playerID, Action: "attack", Move: i}
+		}
+	}
+	for i := range player.Pokemon {
+		if checkSwitchTarget(player, i) == nil && hasPPLeft(&player.Pokemon[i]) {
+			return Action{PlayerID: playerID, Action: "switch", Switch: i}
+		}
+	}
+	return Action{PlayerID: playerID, Action: "forfeit"}
+}
+
+// hasPPLeft reports whether any of the Pokémon's moves can still be used.
+func hasPPLeft(pokemon *Pokemon) bool {
+	for _, move := range pokemon.Moves {
+		if move.PP > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SubmitAction stores a player's choice for the current simultaneous round and
+// reports whether both players have now chosen.
+func SubmitAction(battle *Battle, action Action) (bool, error) {
+	action.Action = strings.ToLower(action.Action)
+	if err := validateAction(battle, action); err != nil {
+		return false, err
+	}
+	if _, ok := battle.PendingActions[action.PlayerID]; ok {
+		return false, fmt.Errorf("%s already chose an action this round", action.PlayerID)
+	}
+	if battle.PendingActions == nil {
+		battle.PendingActions = make(map[string]Action)
+	}
+	battle.PendingActions[action.PlayerID] = action
+	battle.record(InputSubmit, action)
+	return len(battle.PendingActions) == 2, nil
+}
+
+// actionPriority returns the priority bracket an action is resolved in.
+func actionPriority(battle *Battle, action Action) int {
+	switch action.Action {
+	case "switch":
+		return switchPriority
+	case "attack":
+		player, _ := battle.player(action.PlayerID)
+		return player.ActivePokemon().Moves[action.Move].Priority
+	}
+	return 0
+}
+
+// orderActions sorts actions by priority, then speed, breaking speed ties with
+// the battle's seeded random source.
+func orderActions(battle *Battle, actions []Action) {
+	tieBreak := make(map[string]int, len(actions))
+	for _, action := range actions {
+		tieBreak[action.PlayerID] = battle.random().Int()
+	}
+	sort.SliceStable(actions, func(i, j int) bool {
+		pi, pj := actionPriority(battle, actions[i]), actionPriority(battle, actions[j])
+		if pi != pj {
+			return pi > pj
+		}
+		playerI, _ := battle.player(actions[i].PlayerID)
+		playerJ, _ := battle.player(actions[j].PlayerID)
+		si, sj := playerI.ActivePokemon().EffectiveSpeed(), playerJ.ActivePokemon().EffectiveSpeed()
+		if si != sj {
+			return si > sj
+		}
+		return tieBreak[actions[i].PlayerID] > tieBreak[actions[j].PlayerID]
+	})
+}
+
+// ResolveRound carries out both pending actions in priority and speed order
+// and advances the battle to the next round.
+func ResolveRound(battle *Battle) error {
+	if len(battle.PendingActions) != 2 {
+		return fmt.Errorf("waiting for both players to choose an action")
+	}
+	return battle.logEvents(battle.Turn, func() error {
+		resolveRound(battle)
+		return nil
+	})
+}
+
+func resolveRound(battle *Battle) {
+	actions := []Action{
+		battle.PendingActions[battle.Player1.ID],
+		battle.PendingActions[battle.Player2.ID],
+	}
+	orderActions(battle, actions)
+
+	for _, action := range actions {
+		// A Pokémon that fainted earlier in the round doesn't get to act
+		player, _ := battle.player(action.PlayerID)
+		if player.ActivePokemon().HP <= 0 {
+			continue
+		}
+		if err := ExecuteAction(battle, action); err != nil {
+			log.Printf("Round %d: %s could not act: %v", battle.Turn, action.PlayerID, err)
+		}
+	}
+
+	for _, player := range []*Player{&battle.Player1, &battle.Player2} {
+		endOfTurn(battle, player)
+	}
+	updateStatus(battle)
+
+	battle.PendingActions = nil
+	battle.Turn++
+}
+
+// FinishTurn applies the acting player's end-of-turn effects and passes the
+// turn to the other player.
+func FinishTurn(battle *Battle, playerID string) {
+	if player, err := battle.player(playerID); err == nil {
+		endOfTurn(battle, player)
+	}
+	updateStatus(battle)
+	battle.Turn++
+}
+
+// endOfTurn deals residual status damage to the player's active Pokémon.
+func endOfTurn(battle *Battle, player *Player) {
+	active := player.ActivePokemon()
+	if active.HP <= 0 {
+		return
+	}
+	if applyResidualDamage(battle, active) > 0 {
+		checkFainted(battle, player)
+	}
+}
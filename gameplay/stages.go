@@ -0,0 +1,142 @@
+package gameplay
+
+import "log"
+
+// Stat stages run from -6 to +6 and reset when the Pokémon switches out.
+const (
+	minStage = -6
+	maxStage = 6
+)
+
+// Stat names used by stage-changing moves, matching the PokéAPI names.
+const (
+	StatAttack         = "attack"
+	StatDefense        = "defense"
+	StatSpecialAttack  = "special-attack"
+	StatSpecialDefense = "special-defense"
+	StatSpeed          = "speed"
+	StatAccuracy       = "accuracy"
+	StatEvasion        = "evasion"
+)
+
+// StatStages holds the in-battle buffs and debuffs of a Pokémon.
+type StatStages struct {
+	Attack         int `json:"attack"`
+	Defense        int `json:"defense"`
+	SpecialAttack  int `json:"special_attack"`
+	SpecialDefense int `json:"special_defense"`
+	Speed          int `json:"speed"`
+	Accuracy       int `json:"accuracy"`
+	Evasion        int `json:"evasion"`
+}
+
+// StatChange is a stage change a move makes to one stat.
+type StatChange struct {
+	Stat   string `json:"stat"`
+	Change int    `json:"change"`
+}
+
+// StageChange records a stage change that was actually applied.
+type StageChange struct {
+	Pokemon string `json:"pokemon"`
+	Stat    string `json:"stat"`
+	Change  int    `json:"change"`
+}
+
+func (stages *StatStages) stage(stat string) *int {
+	switch stat {
+	case StatAttack:
+		return &stages.Attack
+	case StatDefense:
+		return &stages.Defense
+	case StatSpecialAttack:
+		return &stages.SpecialAttack
+	case StatSpecialDefense:
+		return &stages.SpecialDefense
+	case StatSpeed:
+		return &stages.Speed
+	case StatAccuracy:
+		return &stages.Accuracy
+	case StatEvasion:
+		return &stages.Evasion
+	}
+	return nil
+}
+
+// changeStage moves a stat stage by delta within -6..+6 and returns how far it
+// actually moved.
+func changeStage(pokemon *Pokemon, stat string, delta int) int {
+	stage := pokemon.Stages.stage(stat)
+	if stage == nil {
+		return 0
+	}
+	changed := clampStage(*stage+delta) - *stage
+	*stage += changed
+	switch {
+	case changed > 0:
+		log.Printf("%s's %s rose by %d!", pokemon.Name, stat, changed)
+	case changed < 0:
+		log.Printf("%s's %s fell by %d!", pokemon.Name, stat, -changed)
+	default:
+		log.Printf("%s's %s won't go any further!", pokemon.Name, stat)
+	}
+	return changed
+}
+
+// applyStatChanges applies every change to the Pokémon and returns the ones
+// that took effect.
+func applyStatChanges(battle *Battle, pokemon *Pokemon, changes []StatChange) []StageChange {
+	var applied []StageChange
+	for _, change := range changes {
+		if changed := changeStage(pokemon, change.Stat, change.Change); changed != 0 {
+			applied = append(applied, StageChange{Pokemon: pokemon.Name, Stat: change.Stat, Change: changed})
+			event := battle.event(EventStatChange, pokemon)
+			event.Stat = change.Stat
+			event.Change = changed
+			battle.emit(event)
+		}
+	}
+	return applied
+}
+
+// applyMoveStatChanges applies a move's stage changes to whichever side the
+// move targets.
+func applyMoveStatChanges(battle *Battle, attacker, defender *Pokemon, move *Move) []StageChange {
+	target := defender
+	if move.StatTarget == "user" {
+		target = attacker
+	}
+	if target.HP <= 0 {
+		return nil
+	}
+	return applyStatChanges(battle, target, move.StatChanges)
+}
+
+func clampStage(stage int) int {
+	if stage < minStage {
+		return minStage
+	}
+	if stage > maxStage {
+		return maxStage
+	}
+	return stage
+}
+
+// applyStage scales a battle stat by its stage: x(2+n)/2 when raised and
+// x2/(2+n) when lowered.
+func applyStage(value, stage int) int {
+	if stage >= 0 {
+		return value * (2 + stage) / 2
+	}
+	return value * 2 / (2 - stage)
+}
+
+// accuracyPercent scales a move's accuracy by the attacker's accuracy stage
+// against the defender's evasion stage, using thirds instead of halves.
+func accuracyPercent(accuracy int, attacker, defender *Pokemon) int {
+	stage := clampStage(attacker.Stages.Accuracy - defender.Stages.Evasion)
+	if stage >= 0 {
+		return accuracy * (3 + stage) / 3
+	}
+	return accuracy * 3 / (3 - stage)
+}
@@ -0,0 +1,245 @@
+package gameplay
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultLevel is the level a battle Pokémon gets when none is given.
+	DefaultLevel = 50
+	MaxLevel     = 100
+
+	MaxIV      = 31
+	MaxEV      = 252
+	MaxTotalEV = 510
+
+	// DefaultNature doesn't change any stat.
+	DefaultNature = "hardy"
+)
+
+// natures maps each nature to the stat it raises by 10% and the one it lowers
+// by 10%. Natures that raise and lower the same stat are neutral.
+var natures = map[string][2]string{
+	"hardy":   {StatAttack, StatAttack},
+	"lonely":  {StatAttack, StatDefense},
+	"brave":   {StatAttack, StatSpeed},
+	"adamant": {StatAttack, StatSpecialAttack},
+	"naughty": {StatAttack, StatSpecialDefense},
+	"bold":    {StatDefense, StatAttack},
+	"docile":  {StatDefense, StatDefense},
+	"relaxed": {StatDefense, StatSpeed},
+	"impish":  {StatDefense, StatSpecialAttack},
+	"lax":     {StatDefense, StatSpecialDefense},
+	"timid":   {StatSpeed, StatAttack},
+	"hasty":   {StatSpeed, StatDefense},
+	"serious": {StatSpeed, StatSpeed},
+	"jolly":   {StatSpeed, StatSpecialAttack},
+	"naive":   {StatSpeed, StatSpecialDefense},
+	"modest":  {StatSpecialAttack, StatAttack},
+	"mild":    {StatSpecialAttack, StatDefense},
+	"quiet":   {StatSpecialAttack, StatSpeed},
+	"bashful": {StatSpecialAttack, StatSpecialAttack},
+	"rash":    {StatSpecialAttack, StatSpecialDefense},
+	"calm":    {StatSpecialDefense, StatAttack},
+	"gentle":  {StatSpecialDefense, StatDefense},
+	"sassy":   {StatSpecialDefense, StatSpeed},
+	"careful": {StatSpecialDefense, StatSpecialAttack},
+	"quirky":  {StatSpecialDefense, StatSpecialDefense},
+}
+
+// PokemonOptions describes how a battle Pokémon was raised. Zero values fall
+// back to level 50, perfect IVs, no EVs, a neutral nature and the default
+// moveset.
+type PokemonOptions struct {
+	Level  int      `json:"level"`
+	IVs    *StatSet `json:"ivs"`
+	EVs    StatSet  `json:"evs"`
+	Nature string   `json:"nature"`
+	Moves  []string `json:"moves"`
+}
+
+// StatSet holds one value for each of the six stats.
+type StatSet struct {
+	HP             int `json:"hp"`
+	Attack         int `json:"attack"`
+	Defense        int `json:"defense"`
+	SpecialAttack  int `json:"special_attack"`
+	SpecialDefense int `json:"special_defense"`
+	Speed          int `json:"speed"`
+}
+
+// set assigns a stat by its PokéAPI name, ignoring names it doesn't know.
+func (stats *StatSet) set(name string, value int) {
+	switch name {
+	case "hp":
+		stats.HP = value
+	case "attack":
+		stats.Attack = value
+	case "defense":
+		stats.Defense = value
+	case "special-attack":
+		stats.SpecialAttack = value
+	case "special-defense":
+		stats.SpecialDefense = value
+	case "speed":
+		stats.Speed = value
+	}
+}
+
+func (stats StatSet) values() []int {
+	return []int{stats.HP, stats.Attack, stats.Defense, stats.SpecialAttack, stats.SpecialDefense, stats.Speed}
+}
+
+func (stats StatSet) total() int {
+	total := 0
+	for _, value := range stats.values() {
+		total += value
+	}
+	return total
+}
+
+func perfectIVs() StatSet {
+	return StatSet{MaxIV, MaxIV, MaxIV, MaxIV, MaxIV, MaxIV}
+}
+
+// natureModifier returns the nature's effect on a stat in tenths: 11, 10 or 9.
+func natureModifier(nature, stat string) int {
+	effect := natures[nature]
+	switch {
+	case effect[0] == effect[1]:
+		return 10
+	case effect[0] == stat:
+		return 11
+	case effect[1] == stat:
+		return 9
+	}
+	return 10
+}
+
+// calculateStats applies the standard stat formulas to base stats, IVs, EVs,
+// level and nature.
+func calculateStats(base, ivs, evs StatSet, level int, nature string) StatSet {
+	stat := func(name string, b, iv, ev int) int {
+		value := (2*b+iv+ev/4)*level/100 + 5
+		return value * natureModifier(nature, name) / 10
+	}
+	return StatSet{
+		HP:             (2*base.HP+ivs.HP+evs.HP/4)*level/100 + level + 10,
+		Attack:         stat(StatAttack, base.Attack, ivs.Attack, evs.Attack),
+		Defense:        stat(StatDefense, base.Defense, ivs.Defense, evs.Defense),
+		SpecialAttack:  stat(StatSpecialAttack, base.SpecialAttack, ivs.SpecialAttack, evs.SpecialAttack),
+		SpecialDefense: stat(StatSpecialDefense, base.SpecialDefense, ivs.SpecialDefense, evs.SpecialDefense),
+		Speed:          stat(StatSpeed, base.Speed, ivs.Speed, evs.Speed),
+	}
+}
+
+// RecalculateStats recomputes the battle stats from the Pokémon's level, IVs,
+// EVs and nature. Current HP moves by the same amount as max HP.
+func (pokemon *Pokemon) RecalculateStats() {
+	stats := calculateStats(pokemon.BaseStats, pokemon.IVs, pokemon.EVs, pokemon.Level, pokemon.Nature)
+	pokemon.HP += stats.HP - pokemon.MaxHP
+	pokemon.MaxHP = stats.HP
+	pokemon.Attack = stats.Attack
+	pokemon.Defense = stats.Defense
+	pokemon.SpecialAttack = stats.SpecialAttack
+	pokemon.SpecialDefense = stats.SpecialDefense
+	pokemon.Speed = stats.Speed
+}
+
+// validate checks the options are within the game's limits.
+func (options *PokemonOptions) validate() error {
+	if options.Level < 0 || options.Level > MaxLevel {
+		return fmt.Errorf("level must be between 1 and %d", MaxLevel)
+	}
+	if options.IVs != nil {
+		for _, iv := range options.IVs.values() {
+			if iv < 0 || iv > MaxIV {
+				return fmt.Errorf("IVs must be between 0 and %d", MaxIV)
+			}
+		}
+	}
+	for _, ev := range options.EVs.values() {
+		if ev < 0 || ev > MaxEV {
+			return fmt.Errorf("EVs must be between 0 and %d", MaxEV)
+		}
+	}
+	if options.EVs.total() > MaxTotalEV {
+		return fmt.Errorf("EVs can't total more than %d", MaxTotalEV)
+	}
+	if _, ok := natures[strings.ToLower(options.Nature)]; options.Nature != "" && !ok {
+		return fmt.Errorf("unknown nature %s", options.Nature)
+	}
+	if len(options.Moves) > MaxMoves {
+		return fmt.Errorf("a Pokémon can't know more than %d moves", MaxMoves)
+	}
+	return nil
+}
+
+// NewBattlePokemon reads a species by number and raises it according to the
+// options.
+func NewBattlePokemon(number string, options PokemonOptions) (Pokemon, error) {
+	if err := options.validate(); err != nil {
+		return Pokemon{}, err
+	}
+	pokemon, err := ReadPokemonData(number)
+	if err != nil {
+		return Pokemon{}, err
+	}
+
+	if options.Level > 0 {
+		pokemon.Level = options.Level
+		pokemon.Experience = ExperienceForLevel(pokemon.Level)
+	}
+	if options.IVs != nil {
+		pokemon.IVs = *options.IVs
+	}
+	pokemon.EVs = options.EVs
+	if options.Nature != "" {
+		pokemon.Nature = strings.ToLower(options.Nature)
+	}
+	pokemon.RecalculateStats()
+
+	if len(options.Moves) > 0 {
+		if err := pokemon.SetMoves(options.Moves); err != nil {
+			return Pokemon{}, err
+		}
+	}
+	return pokemon, nil
+}
+
+// offenseAndDefense picks the staged stats a move is calculated with: attack
+// against defense for physical moves, special attack against special defense
+// for special ones.
+func offenseAndDefense(attacker, defender *Pokemon, move *Move) (int, int) {
+	if move.Special {
+		return applyStage(attacker.SpecialAttack, attacker.Stages.SpecialAttack),
+			applyStage(defender.SpecialDefense, defender.Stages.SpecialDefense)
+	}
+	return applyStage(attacker.Attack, attacker.Stages.Attack),
+		applyStage(defender.Defense, defender.Stages.Defense)
+}
+
+const (
+	criticalHitOdds       = 24 // one attack in this many is a critical hit
+	criticalHitMultiplier = 1.5
+	minDamageRoll         = 85 // damage is scaled by a random 85-100 percent
+)
+
+// rollCriticalHit decides whether a damaging move lands a critical hit.
+func rollCriticalHit(battle *Battle) bool {
+	return battle.random().Intn(criticalHitOdds) == 0
+}
+
+// rollDamage scales damage by the random 85-100% damage roll.
+func rollDamage(battle *Battle, damage int) int {
+	return damage * (minDamageRoll + battle.random().Intn(100-minDamageRoll+1)) / 100
+}
+
+// baseDamage applies the standard damage formula before any modifiers.
+func baseDamage(level, power, attack, defense int) int {
+	if defense < 1 {
+		defense = 1
+	}
+	return (2*level/5+2)*power*attack/defense/50 + 2
+}
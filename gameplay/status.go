@@ -0,0 +1,122 @@
+package gameplay
+
+import "log"
+
+// Major status conditions. A Pokémon can only have one at a time and keeps it
+// when switched out.
+const (
+	StatusNone      = ""
+	StatusPoison    = "poison"
+	StatusBurn      = "burn"
+	StatusParalysis = "paralysis"
+	StatusSleep     = "sleep"
+	StatusFreeze    = "freeze"
+)
+
+const (
+	fullParalysisChance = 25 // percent chance a paralysed Pokémon can't move
+	thawChance          = 20 // percent chance a frozen Pokémon thaws each turn
+	maxSleepTurns       = 3
+)
+
+// statusImmunities lists the types that can never receive a status.
+var statusImmunities = map[string][]string{
+	StatusPoison:    {"poison", "steel"},
+	StatusBurn:      {"fire"},
+	StatusParalysis: {"electric"},
+	StatusFreeze:    {"ice"},
+}
+
+// canReceiveStatus reports whether a status would stick to the Pokémon.
+func canReceiveStatus(pokemon *Pokemon, status string) bool {
+	if status == StatusNone || pokemon.Status != StatusNone || pokemon.HP <= 0 {
+		return false
+	}
+	for _, immune := range statusImmunities[status] {
+		if pokemon.HasType(immune) {
+			return false
+		}
+	}
+	return true
+}
+
+// inflictStatus gives the Pokémon a status if it can receive it.
+func inflictStatus(battle *Battle, pokemon *Pokemon, status string) bool {
+	if !canReceiveStatus(pokemon, status) {
+		return false
+	}
+	pokemon.Status = status
+	if status == StatusSleep {
+		pokemon.SleepTurns = 1 + battle.random().Intn(maxSleepTurns)
+	}
+	log.Printf("%s is now affected by %s!", pokemon.Name, status)
+	event := battle.event(EventStatus, pokemon)
+	event.Status = status
+	battle.emit(event)
+	return true
+}
+
+// checkCanMove rolls the start-of-move status effects and returns the status
+// that stops the Pokémon from moving this turn, if any.
+func checkCanMove(battle *Battle, pokemon *Pokemon) string {
+	prevented := battle.event(EventPrevented, pokemon)
+	cured := battle.event(EventCured, pokemon)
+	cured.Status = pokemon.Status
+	switch pokemon.Status {
+	case StatusSleep:
+		if pokemon.SleepTurns > 0 {
+			pokemon.SleepTurns--
+			log.Printf("%s is fast asleep.", pokemon.Name)
+			prevented.Status = StatusSleep
+			battle.emit(prevented)
+			return StatusSleep
+		}
+		pokemon.Status = StatusNone
+		log.Printf("%s woke up!", pokemon.Name)
+		battle.emit(cured)
+	case StatusFreeze:
+		if battle.random().Intn(100) >= thawChance {
+			log.Printf("%s is frozen solid!", pokemon.Name)
+			prevented.Status = StatusFreeze
+			battle.emit(prevented)
+			return StatusFreeze
+		}
+		pokemon.Status = StatusNone
+		log.Printf("%s thawed out!", pokemon.Name)
+		battle.emit(cured)
+	case StatusParalysis:
+		if battle.random().Intn(100) < fullParalysisChance {
+			log.Printf("%s is paralyzed! It can't move!", pokemon.Name)
+			prevented.Status = StatusParalysis
+			battle.emit(prevented)
+			return StatusParalysis
+		}
+	}
+	return StatusNone
+}
+
+// applyResidualDamage deals the end-of-turn damage from poison and burn and
+// returns the HP lost.
+func applyResidualDamage(battle *Battle, pokemon *Pokemon) int {
+	var damage int
+	switch pokemon.Status {
+	case StatusPoison:
+		damage = pokemon.MaxHP / 8
+	case StatusBurn:
+		damage = pokemon.MaxHP / 16
+	default:
+		return 0
+	}
+	if damage < 1 {
+		damage = 1
+	}
+	if damage > pokemon.HP {
+		damage = pokemon.HP
+	}
+	pokemon.HP -= damage
+	log.Printf("%s was hurt by its %s! (%d damage)", pokemon.Name, pokemon.Status, damage)
+	event := battle.damageEvent(pokemon, pokemon.HP+damage)
+	event.Status = pokemon.Status
+	battle.emit(event)
+	return damage
+}
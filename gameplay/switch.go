@@ -0,0 +1,93 @@
+package gameplay
+
+import (
+	"fmt"
+	"log"
+)
+
+// switchPriority makes switching out happen before any move in a round.
+const switchPriority = 6
+
+// checkSwitchTarget verifies that a party member can be sent into battle.
+func checkSwitchTarget(player *Player, index int) error {
+	if index < 0 || index >= len(player.Pokemon) {
+		return fmt.Errorf("%s has no Pokémon in party slot %d", player.Name, index+1)
+	}
+	if index == player.CurrentPokemonIndex {
+		return fmt.Errorf("%s is already in battle", player.Pokemon[index].Name)
+	}
+	if player.Pokemon[index].HP <= 0 {
+		return fmt.Errorf("%s has fainted and can't battle", player.Pokemon[index].Name)
+	}
+	return nil
+}
+
+// sendOut replaces the player's active Pokémon with the party member at index.
+func sendOut(battle *Battle, player *Player, index int) {
+	outgoing := player.ActivePokemon()
+	outgoing.Stages = StatStages{}
+
+	player.CurrentPokemonIndex = index
+	player.MustSwitch = false
+	log.Printf("%s withdrew %s and sent out %s!", player.Name, outgoing.Name, player.ActivePokemon().Name)
+	event := battle.event(EventSwitch, player.ActivePokemon())
+	event.Withdrawn = outgoing.Name
+	battle.emit(event)
+}
+
+// ExecuteSwitch voluntarily switches the player's active Pokémon, using up
+// their turn.
+func ExecuteSwitch(battle *Battle, playerID string, index int) error {
+	player, err := battle.player(playerID)
+	if err != nil {
+		return err
+	}
+	if err := checkSwitchTarget(player, index); err != nil {
+		return err
+	}
+	sendOut(battle, player, index)
+	return nil
+}
+
+// ReplaceFainted sends in the replacement a player picked after their active
+// Pokémon fainted. It doesn't use up a turn, and is logged with the turn the
+// Pokémon fainted in.
+func ReplaceFainted(battle *Battle, playerID string, index int) error {
+	return battle.logEvents(battle.Turn-1, func() error {
+		if err := checkInProgress(battle); err != nil {
+			return err
+		}
+		player, err := battle.player(playerID)
+		if err != nil {
+			return err
+		}
+		if !player.MustSwitch {
+			return fmt.Errorf("%s doesn't need to send out a replacement", player.Name)
+		}
+		if err := checkSwitchTarget(player, index); err != nil {
+			return err
+		}
+		sendOut(battle, player, index)
+		battle.record(InputReplace, Action{PlayerID: playerID, Action: "switch", Switch: index})
+		return nil
+	})
+}
+
+// pendingReplacement returns a player who still has to replace a fainted
+// Pokémon, or nil if no one does.
+func pendingReplacement(battle *Battle) *Player {
+	if battle.Player1.MustSwitch {
+		return &battle.Player1
+	}
+	if battle.Player2.MustSwitch {
+		return &battle.Player2
+	}
+	return nil
+}
+
+// NeedsReplacement reports whether the player has to pick a replacement for a
+// fainted Pokémon before the battle can continue.
+func NeedsReplacement(battle *Battle, playerID string) bool {
+	player, err := battle.player(playerID)
+	return err == nil && player.MustSwitch
+}
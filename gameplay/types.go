@@ -0,0 +1,113 @@
+package gameplay
+
+// typeChart holds the attacking-type → defending-type multipliers that differ
+// from neutral (1.0). Any pairing missing from the chart is neutral.
+var typeChart = map[string]map[string]float64{
+	"normal": {
+		"rock": 0.5, "ghost": 0, "steel": 0.5,
+	},
+	"fire": {
+		"fire": 0.5, "water": 0.5, "grass": 2, "ice": 2, "bug": 2,
+		"rock": 0.5, "dragon": 0.5, "steel": 2,
+	},
+	"water": {
+		"fire": 2, "water": 0.5, "grass": 0.5, "ground": 2, "rock": 2,
+		"dragon": 0.5,
+	},
+	"electric": {
+		"water": 2, "electric": 0.5, "grass": 0.5, "ground": 0, "flying": 2,
+		"dragon": 0.5,
+	},
+	"grass": {
+		"fire": 0.5, "water": 2, "grass": 0.5, "poison": 0.5, "ground": 2,
+		"flying": 0.5, "bug": 0.5, "rock": 2, "dragon": 0.5, "steel": 0.5,
+	},
+	"ice": {
+		"fire": 0.5, "water": 0.5, "grass": 2, "ice": 0.5, "ground": 2,
+		"flying": 2, "dragon": 2, "steel": 0.5,
+	},
+	"fighting": {
+		"normal": 2, "ice": 2, "poison": 0.5, "flying": 0.5, "psychic": 0.5,
+		"bug": 0.5, "rock": 2, "ghost": 0, "dark": 2, "steel": 2, "fairy": 0.5,
+	},
+	"poison": {
+		"grass": 2, "poison": 0.5, "ground": 0.5, "rock": 0.5, "ghost": 0.5,
+		"steel": 0, "fairy": 2,
+	},
+	"ground": {
+		"fire": 2, "electric": 2, "grass": 0.5, "poison": 2, "flying": 0,
+		"bug": 0.5, "rock": 2, "steel": 2,
+	},
+	"flying": {
+		"electric": 0.5, "grass": 2, "fighting": 2, "bug": 2, "rock": 0.5,
+		"steel": 0.5,
+	},
+	"psychic": {
+		"fighting": 2, "poison": 2, "psychic": 0.5, "dark": 0, "steel": 0.5,
+	},
+	"bug": {
+		"fire": 0.5, "grass": 2, "fighting": 0.5, "poison": 0.5, "flying": 0.5,
+		"psychic": 2, "ghost": 0.5, "dark": 2, "steel": 0.5, "fairy": 0.5,
+	},
+	"rock": {
+		"fire": 2, "ice": 2, "fighting": 0.5, "ground": 0.5, "flying": 2,
+		"bug": 2, "steel": 0.5,
+	},
+	"ghost": {
+		"normal": 0, "psychic": 2, "ghost": 2, "dark": 0.5,
+	},
+	"dragon": {
+		"dragon": 2, "steel": 0.5, "fairy": 0,
+	},
+	"dark": {
+		"fighting": 0.5, "psychic": 2, "ghost": 2, "dark": 0.5, "fairy": 0.5,
+	},
+	"steel": {
+		"fire": 0.5, "water": 0.5, "electric": 0.5, "ice": 2, "rock": 2,
+		"steel": 0.5, "fairy": 2,
+	},
+	"fairy": {
+		"fire": 0.5, "fighting": 2, "poison": 0.5, "dragon": 2, "dark": 2,
+		"steel": 0.5,
+	},
+}
+
+// stabMultiplier is the same-type attack bonus applied when the attacker
+// shares a type with the move it uses.
+const stabMultiplier = 1.5
+
+// TypeEffectiveness returns the combined multiplier of a move type against
+// every type of the defender, so dual types stack (e.g. 4x or 0.25x).
+func TypeEffectiveness(moveType string, defender *Pokemon) float64 {
+	multiplier := 1.0
+	for _, t := range defender.Types {
+		if m, ok := typeChart[moveType][t.Type.Name]; ok {
+			multiplier *= m
+		}
+	}
+	return multiplier
+}
+
+// HasType reports whether the Pokémon has the given type.
+func (pokemon *Pokemon) HasType(typeName string) bool {
+	for _, t := range pokemon.Types {
+		if t.Type.Name == typeName {
+			return true
+		}
+	}
+	return false
+}
+
+// EffectivenessMessage returns the in-game text for an effectiveness
+// multiplier, or an empty string when the hit is neutral.
+func EffectivenessMessage(multiplier float64) string {
+	switch {
+	case multiplier == 0:
+		return "It doesn't affect the target..."
+	case multiplier > 1:
+		return "It's super effective!"
+	case multiplier < 1:
+		return "It's not very effective..."
+	}
+	return ""
+}
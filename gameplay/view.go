@@ -0,0 +1,216 @@
+package gameplay
+
+import (
+	"slices"
+	"sort"
+)
+
+// BattleView is the battle as one viewer sees it: each side's Pokémon only
+// once they have been sent out, their HP and the damage they take as a share
+// of the maximum, and only the moves they have used. A player also sees their
+// own team in full, and the exact damage it takes. Choices made for a round
+// still being played show as who has chosen, never what.
+type BattleView struct {
+	ID           string   `json:"id"`
+	Turn         int      `json:"turn"`
+	Mode         string   `json:"mode"`
+	Status       string   `json:"status"`
+	Winner       string   `json:"winner,omitempty"`
+	TurnTimeLeft int      `json:"turn_time_left,omitempty"`
+	Player1      SideView `json:"player1"`
+	Player2      SideView `json:"player2"`
+	Chosen       []string `json:"chosen,omitempty"` // players who have chosen this round
+	Events       []Event  `json:"events,omitempty"`
+}
+
+// SideView is one player's side of a BattleView.
+type SideView struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	MustSwitch bool   `json:"must_switch"`
+	TeamSize   int    `json:"team_size"`
+	Remaining  int    `json:"remaining"` // Pokémon that haven't fainted
+	// Pokemon lists the revealed Pokémon in the order they were first seen
+	Pokemon []RevealedPokemon `json:"pokemon"`
+	// Team is the whole party in full and CurrentPokemonIndex the active
+	// member, only on the viewer's own side
+	Team                []Pokemon `json:"team,omitempty"`
+	CurrentPokemonIndex int       `json:"current_pokemon_index,omitempty"`
+}
+
+// RevealedPokemon is what has been seen of one Pokémon.
+type RevealedPokemon struct {
+	Name      string     `json:"name"`
+	Level     int        `json:"level"`
+	Types     []string   `json:"types"`
+	HPPercent int        `json:"hp_percent"`
+	Status    string     `json:"status"`
+	Stages    StatStages `json:"stages"`
+	Active    bool       `json:"active"`
+	Fainted   bool       `json:"fainted"`
+	Moves     []string   `json:"moves"` // the moves it has used
+}
+
+// hpPercent rounds the Pokémon's HP to a whole percentage, never showing 0
+// for a Pokémon that is still standing.
+func hpPercent(pokemon *Pokemon) int {
+	return hpShare(pokemon.HP, pokemon.MaxHP)
+}
+
+func hpShare(hp, maxHP int) int {
+	if hp <= 0 || maxHP <= 0 {
+		return 0
+	}
+	return max(1, (hp*100+maxHP/2)/maxHP)
+}
+
+// eventsFor hides the exact HP lost by Pokémon that aren't the viewer's,
+// leaving the share their HP bar shows. Spectators have no Pokémon of their
+// own, so they see only shares.
+func eventsFor(events []Event, viewerID string) []Event {
+	if events == nil {
+		return nil
+	}
+	redacted := slices.Clone(events)
+	for i := range redacted {
+		if redacted[i].Kind == EventDamage && redacted[i].PlayerID != viewerID {
+			redacted[i].Damage = 0
+		}
+	}
+	return redacted
+}
+
+// historyFor is the battle's history with each turn's events as the viewer
+// may see them.
+func (battle *Battle) historyFor(viewerID string) []TurnLog {
+	history := make([]TurnLog, len(battle.History))
+	for i, turn := range battle.History {
+		history[i] = TurnLog{Turn: turn.Turn, Events: eventsFor(turn.Events, viewerID)}
+	}
+	return history
+}
+
+// revealedSide builds what has been seen of a player's side from the battle's
+// history: a Pokémon is revealed once it is active or named in an event
+// about its owner, and a move once it has been used.
+func (battle *Battle) revealedSide(player *Player) SideView {
+	side := SideView{ID: player.ID, Name: player.Name, MustSwitch: player.MustSwitch, TeamSize: len(player.Pokemon), Pokemon: []RevealedPokemon{}}
+	for i := range player.Pokemon {
+		if player.Pokemon[i].HP > 0 {
+			side.Remaining++
+		}
+	}
+	if len(player.Pokemon) == 0 {
+		return side
+	}
+
+	firstSeen := make(map[string]int)
+	usedMoves := make(map[string][]string)
+	see := func(name string) {
+		if _, ok := firstSeen[name]; !ok && name != "" {
+			firstSeen[name] = len(firstSeen)
+		}
+	}
+	for _, turn := range battle.History {
+		for _, event := range turn.Events {
+			if event.PlayerID != player.ID {
+				continue
+			}
+			see(event.Withdrawn)
+			see(event.Pokemon)
+			if event.Kind == EventMove && !slices.Contains(usedMoves[event.Pokemon], event.Move) {
+				usedMoves[event.Pokemon] = append(usedMoves[event.Pokemon], event.Move)
+			}
+		}
+	}
+	if battle.Status != BattlePending {
+		see(player.ActivePokemon().Name)
+	}
+
+	for i := range player.Pokemon {
+		pokemon := &player.Pokemon[i]
+		if _, ok := firstSeen[pokemon.Name]; !ok {
+			continue
+		}
+		revealed := RevealedPokemon{
+			Name:      pokemon.Name,
+			Level:     pokemon.Level,
+			Types:     []string{},
+			HPPercent: hpPercent(pokemon),
+			Status:    pokemon.Status,
+			Stages:    pokemon.Stages,
+			Active:    i == player.CurrentPokemonIndex && battle.Status != BattlePending,
+			Fainted:   pokemon.HP <= 0,
+			Moves:     []string{},
+		}
+		for _, t := range pokemon.Types {
+			revealed.Types = append(revealed.Types, t.Type.Name)
+		}
+		// Defending isn't one of the Pokémon's moves
+		for _, move := range pokemon.Moves {
+			if slices.Contains(usedMoves[pokemon.Name], move.Name) {
+				revealed.Moves = append(revealed.Moves, move.Name)
+			}
+		}
+		side.Pokemon = append(side.Pokemon, revealed)
+	}
+	sort.SliceStable(side.Pokemon, func(i, j int) bool {
+		return firstSeen[side.Pokemon[i].Name] < firstSeen[side.Pokemon[j].Name]
+	})
+	return side
+}
+
+// SpectatorView returns the battle as a spectator may see it.
+func SpectatorView(battle *Battle) BattleView {
+	return battle.revealedView("")
+}
+
+// SpectatorHistory returns the battle's history as a spectator may see it.
+func SpectatorHistory(battle *Battle) []TurnLog {
+	return battle.historyFor("")
+}
+
+// PlayerHistory returns the battle's history as the player may see it: the
+// exact damage their own Pokémon took, and only shares of the opponent's HP.
+func PlayerHistory(battle *Battle, playerID string) []TurnLog {
+	return battle.historyFor(playerID)
+}
+
+// PlayerView returns the battle as the player may see it: their own side in
+// full and only what has been revealed of the opponent's.
+func PlayerView(battle *Battle, playerID string) BattleView {
+	view := battle.revealedView(playerID)
+	own := &view.Player1
+	if playerID == battle.Player2.ID {
+		own = &view.Player2
+	}
+	player, err := battle.player(playerID)
+	if err != nil {
+		return view
+	}
+	own.Team = player.Pokemon
+	own.CurrentPokemonIndex = player.CurrentPokemonIndex
+	return view
+}
+
+// revealedView shows both sides only as far as they have been revealed, and
+// the latest events as the viewer may see them.
+func (battle *Battle) revealedView(viewerID string) BattleView {
+	view := BattleView{
+		ID:           battle.ID,
+		Turn:         battle.Turn,
+		Mode:         battle.Mode,
+		Status:       battle.Status,
+		Winner:       battle.Winner,
+		TurnTimeLeft: battle.TurnTimeLeft,
+		Player1:      battle.revealedSide(&battle.Player1),
+		Player2:      battle.revealedSide(&battle.Player2),
+		Events:       eventsFor(battle.Events, viewerID),
+	}
+	for _, id := range []string{battle.Player1.ID, battle.Player2.ID} {
+		if _, chosen := battle.PendingActions[id]; chosen {
+			view.Chosen = append(view.Chosen, id)
+		}
+	}
+	return view
+}
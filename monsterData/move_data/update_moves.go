@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Run from this folder, after update_data.go has filled ../pokemon_data, to
+// rebuild moves.json and learnsets.json from the PokéAPI.
+
+const apiURL = "https://pokeapi.co/api/v2"
+
+// The statuses the battle engine knows
+var supportedAilments = map[string]bool{
+	"poison":    true,
+	"burn":      true,
+	"paralysis": true,
+	"sleep":     true,
+	"freeze":    true,
+}
+
+type namedResource struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// apiPokemon is the part of a PokéAPI Pokémon that lists its moves
+type apiPokemon struct {
+	Name  string `json:"name"`
+	Moves []struct {
+		Move                namedResource `json:"move"`
+		VersionGroupDetails []struct {
+			LevelLearnedAt  int           `json:"level_learned_at"`
+			MoveLearnMethod namedResource `json:"move_learn_method"`
+			VersionGroup    namedResource `json:"version_group"`
+		} `json:"version_group_details"`
+	} `json:"moves"`
+}
+
+// apiMove is a PokéAPI move
+type apiMove struct {
+	Name  string `json:"name"`
+	Names []struct {
+		Name     string        `json:"name"`
+		Language namedResource `json:"language"`
+	} `json:"names"`
+	Type        namedResource `json:"type"`
+	Power       *int          `json:"power"`
+	Accuracy    *int          `json:"accuracy"`
+	PP          int           `json:"pp"`
+	Priority    int           `json:"priority"`
+	DamageClass namedResource `json:"damage_class"`
+	Target      namedResource `json:"target"`
+	Meta        *struct {
+		Ailment       namedResource `json:"ailment"`
+		AilmentChance int           `json:"ailment_chance"`
+		Category      namedResource `json:"category"`
+		StatChance    int           `json:"stat_chance"`
+	} `json:"meta"`
+	StatChanges []struct {
+		Change int           `json:"change"`
+		Stat   namedResource `json:"stat"`
+	} `json:"stat_changes"`
+}
+
+type statChange struct {
+	Stat   string `json:"stat"`
+	Change int    `json:"change"`
+}
+
+// move is an entry of moves.json, as the battle engine reads it
+type move struct {
+	Name          string       `json:"name"`
+	Type          string       `json:"type"`
+	Power         int          `json:"power"`
+	Accuracy      int          `json:"accuracy"`
+	PP            int          `json:"pp"`
+	Category      string       `json:"category"`
+	Priority      int          `json:"priority"`
+	Ailment       string       `json:"ailment,omitempty"`
+	AilmentChance int          `json:"ailment_chance,omitempty"`
+	StatChanges   []statChange `json:"stat_changes,omitempty"`
+	StatTarget    string       `json:"stat_target,omitempty"`
+	StatChance    int          `json:"stat_chance,omitempty"`
+}
+
+func fetchJSON(url string, v interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %s: status code %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %v", url, err)
+	}
+	return nil
+}
+
+// resourceID is the number at the end of a PokéAPI resource URL
+func resourceID(url string) int {
+	id, _ := strconv.Atoi(filepath.Base(strings.TrimSuffix(url, "/")))
+	return id
+}
+
+// Read the names of the species the game has data for
+func readSpecies(folder string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(folder, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		var pokemon struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(data, &pokemon); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %v", file, err)
+		}
+		names = append(names, pokemon.Name)
+	}
+	return names, nil
+}
+
+// levelUpMoves lists the moves the species learns by levelling up in the
+// newest version group that has any, in the order it learns them
+func levelUpMoves(pokemon apiPokemon) []string {
+	type learned struct {
+		name  string
+		level int
+	}
+	newest := 0
+	for _, m := range pokemon.Moves {
+		for _, detail := range m.VersionGroupDetails {
+			if detail.MoveLearnMethod.Name == "level-up" {
+				newest = max(newest, resourceID(detail.VersionGroup.URL))
+			}
+		}
+	}
+
+	var moves []learned
+	for _, m := range pokemon.Moves {
+		for _, detail := range m.VersionGroupDetails {
+			if detail.MoveLearnMethod.Name == "level-up" && resourceID(detail.VersionGroup.URL) == newest {
+				moves = append(moves, learned{m.Move.Name, detail.LevelLearnedAt})
+				break
+			}
+		}
+	}
+	sort.SliceStable(moves, func(i, j int) bool {
+		if moves[i].level != moves[j].level {
+			return moves[i].level < moves[j].level
+		}
+		return moves[i].name < moves[j].name
+	})
+
+	names := make([]string, len(moves))
+	for i, m := range moves {
+		names[i] = m.name
+	}
+	return names
+}
+
+// convertMove turns a PokéAPI move into a catalogue entry, or returns false
+// for a move the battle engine can't play: damaging moves need a power, and
+// status moves a status or stat change it knows.
+func convertMove(source apiMove) (move, bool) {
+	entry := move{
+		Name:     source.Name,
+		Type:     source.Type.Name,
+		PP:       source.PP,
+		Category: source.DamageClass.Name,
+		Priority: source.Priority,
+	}
+	for _, name := range source.Names {
+		if name.Language.Name == "en" {
+			entry.Name = name.Name
+		}
+	}
+	if source.Power != nil {
+		entry.Power = *source.Power
+	}
+	if source.Accuracy != nil {
+		entry.Accuracy = *source.Accuracy
+	}
+
+	damaging := entry.Category != "status"
+	if source.Meta != nil && supportedAilments[source.Meta.Ailment.Name] {
+		if !damaging || source.Meta.AilmentChance > 0 {
+			entry.Ailment = source.Meta.Ailment.Name
+			entry.AilmentChance = source.Meta.AilmentChance
+		}
+		if !damaging {
+			entry.AilmentChance = 0
+		}
+	}
+	for _, change := range source.StatChanges {
+		entry.StatChanges = append(entry.StatChanges, statChange{Stat: change.Stat.Name, Change: change.Change})
+	}
+	if len(entry.StatChanges) > 0 {
+		entry.StatTarget = "target"
+		if damaging && source.Meta != nil {
+			if source.Meta.Category.Name == "damage+raise" {
+				entry.StatTarget = "user"
+			}
+			entry.StatChance = source.Meta.StatChance
+		} else if strings.HasPrefix(source.Target.Name, "user") {
+			entry.StatTarget = "user"
+		}
+	}
+
+	if damaging {
+		return entry, entry.Power > 0
+	}
+	return entry, entry.Ailment != "" || len(entry.StatChanges) > 0
+}
+
+// Save data as indented JSON
+func saveJSON(filename string, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to marshal %s: %v", filename, err)
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", filename, err)
+	}
+	log.Printf("Saved %s", filename)
+}
+
+func main() {
+	species, err := readSpecies("../pokemon_data")
+	if err != nil {
+		log.Fatalf("Failed to read species: %v", err)
+	}
+
+	moves := make(map[string]move)
+	skipped := make(map[string]bool)
+	learnsets := make(map[string][]string)
+	for _, name := range species {
+		var pokemon apiPokemon
+		if err := fetchJSON(fmt.Sprintf("%s/pokemon/%s", apiURL, name), &pokemon); err != nil {
+			log.Printf("Failed to fetch moves for %s: %v", name, err)
+			continue
+		}
+
+		learnset := []string{}
+		for _, slug := range levelUpMoves(pokemon) {
+			if _, ok := moves[slug]; !ok && !skipped[slug] {
+				var fetched apiMove
+				if err := fetchJSON(fmt.Sprintf("%s/move/%s", apiURL, slug), &fetched); err != nil {
+					log.Printf("Failed to fetch move %s: %v", slug, err)
+					continue
+				}
+				if entry, ok := convertMove(fetched); ok {
+					moves[slug] = entry
+				} else {
+					skipped[slug] = true
+				}
+			}
+			if _, ok := moves[slug]; ok {
+				learnset = append(learnset, slug)
+			}
+		}
+		learnsets[name] = learnset
+		log.Printf("%s learns %d moves", name, len(learnset))
+	}
+
+	saveJSON("moves.json", moves)
+	saveJSON("learnsets.json", learnsets)
+	log.Printf("Saved %d moves, skipped %d the battle engine can't play", len(moves), len(skipped))
+}
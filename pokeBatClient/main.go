@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -9,70 +10,237 @@ import (
 	"net/http"
 	"os"
 	"strings"
-	"time"
 )
 
 type BattleRequest struct {
 	Player1Pokemon []string `json:"player1_pokemon"`
-	Player2Pokemon []string `json:"player2_pokemon"`
+	Player2Name    string   `json:"player2_name,omitempty"`
+	Computer       string   `json:"computer,omitempty"`
+	Mode           string   `json:"mode"`
+}
+
+// LobbyRequest queues for a match, issues or accepts a challenge, or joins
+// an open battle with the player's team
+type LobbyRequest struct {
+	Opponent string   `json:"opponent,omitempty"`
+	Pokemon  []string `json:"pokemon"`
+	Mode     string   `json:"mode,omitempty"`
 }
 
 type ActionRequest struct {
-	PlayerID string `json:"player_id"`
 	Action   string `json:"action"`
+	Move     int    `json:"move"`
+	Switch   int    `json:"switch"`
+}
+
+type MoveState struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Power int    `json:"power"`
+	PP    int    `json:"pp"`
+	MaxPP int    `json:"max_pp"`
+}
+
+// Event is one thing that happened in the battle, as reported by the server
+type Event struct {
+	Kind          string  `json:"kind"`
+	PlayerID      string  `json:"player_id"`
+	Pokemon       string  `json:"pokemon"`
+	Target        string  `json:"target"`
+	Move          string  `json:"move"`
+	Damage        int     `json:"damage"` // only for our own Pokémon
+	DamagePercent int     `json:"damage_percent"`
+	Effectiveness string  `json:"effectiveness"`
+	Multiplier    float64 `json:"multiplier"`
+	Status        string  `json:"status"`
+	Stat          string  `json:"stat"`
+	Change        int     `json:"change"`
+	Experience    int     `json:"experience"`
+	Level         int     `json:"level"`
+	Withdrawn     string  `json:"withdrawn"`
+}
+
+// PokemonState is one of the player's own Pokémon, in full
+type PokemonState struct {
+	Name   string         `json:"name"`
+	Level  int            `json:"level"`
+	HP     int            `json:"hp"`
+	MaxHP  int            `json:"max_hp"`
+	Status string         `json:"status"`
+	Stages map[string]int `json:"stages"`
+	Moves  []MoveState    `json:"moves"`
+}
+
+// RevealedPokemon is what has been seen of a Pokémon that has been sent out:
+// its HP as a percentage and the moves it has used
+type RevealedPokemon struct {
+	Name      string         `json:"name"`
+	Level     int            `json:"level"`
+	HPPercent int            `json:"hp_percent"`
+	Status    string         `json:"status"`
+	Stages    map[string]int `json:"stages"`
+	Active    bool           `json:"active"`
+	Fainted   bool           `json:"fainted"`
+	Moves     []string       `json:"moves"`
+}
+
+// SideView is one side of the battle. Only the player's own side comes with
+// the whole team; every side lists the Pokémon revealed so far.
+type SideView struct {
+	Name                string            `json:"name"`
+	MustSwitch          bool              `json:"must_switch"`
+	TeamSize            int               `json:"team_size"`
+	Remaining           int               `json:"remaining"`
+	Pokemon             []RevealedPokemon `json:"pokemon"`
+	Team                []PokemonState    `json:"team"`
+	CurrentPokemonIndex int               `json:"current_pokemon_index"`
 }
 
 type BattleState struct {
-	Player1 struct {
-		Name    string `json:"name"`
-		Pokemon []struct {
-			Name string `json:"name"`
-			HP   int    `json:"hp"`
-		} `json:"pokemon"`
-	} `json:"player1"`
-	Player2 struct {
-		Name    string `json:"name"`
-		Pokemon []struct {
-			Name string `json:"name"`
-			HP   int    `json:"hp"`
-		} `json:"pokemon"`
-	} `json:"player2"`
-	Turn int `json:"turn"`
+	ID       string   `json:"id"`
+	Player1  SideView `json:"player1"`
+	Player2  SideView `json:"player2"`
+	Turn     int      `json:"turn"`
+	Mode     string   `json:"mode"`
+	Status   string   `json:"status"`
+	Winner   string   `json:"winner"`
+	TimeLeft int      `json:"turn_time_left"`
+	Chosen   []string `json:"chosen"`
+	Events   []Event  `json:"events"`
 }
 
 const serverURL = "http://localhost:8080"
+var playerName string
 var playerID string
+var battleID string
+var sessionToken string
 
-// Helper function to send POST requests
+// Helper function to send POST requests, signed with the session token
 func postRequest(endpoint string, payload interface{}) ([]byte, error) {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal payload: %v", err)
 	}
 
-	resp, err := http.Post(serverURL+endpoint, "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest(http.MethodPost, serverURL+endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create POST request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if sessionToken != "" {
+		req.Header.Set("Authorization", "Bearer "+sessionToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send POST request: %v", err)
 	}
 	defer resp.Body.Close()
 
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("server returned status %d", resp.StatusCode)
+		return nil, serverError(resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+// Describe an error response, listing each problem the server found with a
+// team on its own line
+func serverError(status int, body []byte) error {
+	var teamError struct {
+		Error    string `json:"error"`
+		Problems []struct {
+			Team    string `json:"team"`
+			Member  string `json:"member"`
+			Problem string `json:"problem"`
+		} `json:"problems"`
+	}
+	if err := json.Unmarshal(body, &teamError); err != nil || len(teamError.Problems) == 0 {
+		return fmt.Errorf("server returned status %d: %s", status, strings.TrimSpace(string(body)))
 	}
+	var lines []string
+	for _, problem := range teamError.Problems {
+		if problem.Member != "" {
+			lines = append(lines, fmt.Sprintf("- %s: %s", problem.Member, problem.Problem))
+		} else {
+			lines = append(lines, "- "+problem.Problem)
+		}
+	}
+	return fmt.Errorf("%s:\n%s", teamError.Error, strings.Join(lines, "\n"))
+}
 
-	return ioutil.ReadAll(resp.Body)
+// The file the session token for a player name is kept in between runs
+func tokenFile(name string) string {
+	return fmt.Sprintf(".pokebat-%s.token", name)
+}
+
+// Load the player's saved session token, or log in with their password for a
+// new one, registering the name with the server the first time it is used
+func login(name string) {
+	if token, err := os.ReadFile(tokenFile(name)); err == nil {
+		sessionToken = strings.TrimSpace(string(token))
+		// Any request needing a session tells whether the server still takes it
+		if _, err := getRequest("/progress"); err == nil {
+			return
+		}
+		fmt.Println("Your session has expired, please log in again.")
+		sessionToken = ""
+	}
+
+	var password string
+	fmt.Print("Password: ")
+	fmt.Scanln(&password)
+	credentials := map[string]string{"name": name, "password": password}
+	body, err := postRequest("/login", credentials)
+	registered := false
+	if err != nil {
+		// The name isn't registered yet, or the password is wrong
+		if body, err = postRequest("/register", credentials); err != nil {
+			if strings.Contains(err.Error(), "already registered") {
+				log.Fatalf("Wrong password for %s", name)
+			}
+			log.Fatalf("Failed to register %s: %v", name, err)
+		}
+		registered = true
+	}
+	var session struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &session); err != nil {
+		log.Fatalf("Failed to decode session: %v", err)
+	}
+	sessionToken = session.Token
+	if err := os.WriteFile(tokenFile(name), []byte(sessionToken), 0600); err != nil {
+		log.Printf("Failed to save session token: %v", err)
+	}
+	if registered {
+		fmt.Printf("Registered as %s.\n", name)
+	} else {
+		fmt.Printf("Logged in as %s.\n", name)
+	}
 }
 
 // Fetch updated battle state from the server
 func fetchBattleState() (BattleState, error) {
-	resp, err := http.Get(serverURL + "/battle")
+	if battleID == "" {
+		return BattleState{}, fmt.Errorf("no active battle found")
+	}
+	// Signed with the session token, so a player sees their own team in full
+	req, err := http.NewRequest(http.MethodGet, serverURL+"/battle/"+battleID, nil)
+	if err != nil {
+		return BattleState{}, fmt.Errorf("failed to create battle state request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+sessionToken)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return BattleState{}, fmt.Errorf("failed to fetch battle state: %v", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNoContent {
-		return BattleState{}, fmt.Errorf("no active battle found")
+	if resp.StatusCode == http.StatusNotFound {
+		return BattleState{}, fmt.Errorf("battle %s not found", battleID)
 	}
 
 	var battleState BattleState
@@ -82,156 +250,875 @@ func fetchBattleState() (BattleState, error) {
 	return battleState, nil
 }
 
-// Function to initiate the battle if not already started
-func startBattle() {
-	fmt.Println("No active battle found. Would you like to start a new battle? (yes/no):")
+// Helper function to send GET requests, signed with the session token
+func getRequest(endpoint string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, serverURL+endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GET request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+sessionToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send GET request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, serverError(resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+// Prompt for the team to battle with, e.g. "Pikachu,Charmander,Bulbasaur"
+func chooseTeam(defaultTeam []string) []string {
+	fmt.Printf("Choose your team, separated by commas (leave empty for %s):\n", strings.Join(defaultTeam, ","))
+	var team string
+	fmt.Scanln(&team)
+	if team == "" {
+		return defaultTeam
+	}
+	return strings.Split(team, ",")
+}
+
+// Prompt for the battle mode
+func chooseMode() string {
+	fmt.Println("Battle mode? (turns/simultaneous):")
+	var mode string
+	fmt.Scanln(&mode)
+	return strings.ToLower(mode)
+}
+
+// Find a battle through the lobby: the matchmaking queue, a direct challenge,
+// or an open battle for anyone to join
+func enterLobby() {
+	fmt.Println("1. Quick match")
+	fmt.Println("2. Challenge a player")
+	fmt.Println("3. Answer a challenge")
+	fmt.Println("4. Start an open battle")
+	fmt.Println("5. Practise against the computer")
+	fmt.Println("6. Tournaments")
+	fmt.Println("7. Watch a battle")
+	fmt.Println("Choose an option:")
+	var choice int
+	fmt.Scanln(&choice)
+
+	defaultTeam := []string{"Pikachu", "Charmander", "Bulbasaur"}
+	switch choice {
+	case 1:
+		queueRequest := LobbyRequest{Pokemon: chooseTeam(defaultTeam), Mode: chooseMode()}
+		fmt.Println("Looking for an opponent...")
+		waitForLobby("/lobby/queue", queueRequest)
+	case 2:
+		fmt.Println("Opponent's name:")
+		var opponent string
+		fmt.Scanln(&opponent)
+		challengeRequest := LobbyRequest{Opponent: opponent, Pokemon: chooseTeam(defaultTeam), Mode: chooseMode()}
+		fmt.Printf("Waiting for %s to answer...\n", opponent)
+		waitForLobby("/lobby/challenges", challengeRequest)
+	case 3:
+		answerChallenge(defaultTeam)
+	case 4:
+		startBattle(defaultTeam)
+	case 5:
+		battleComputer(defaultTeam)
+	case 6:
+		enterTournament(defaultTeam)
+	case 7:
+		watchBattle()
+	default:
+		fmt.Println("Exiting the game.")
+		os.Exit(0)
+	}
+}
+
+// Send a lobby request and wait until it produces a battle
+func waitForLobby(endpoint string, lobbyRequest LobbyRequest) {
+	body, err := postRequest(endpoint, lobbyRequest)
+	if err != nil {
+		log.Fatalf("Failed to find a battle: %v", err)
+	}
+	var result struct {
+		Status   string `json:"status"`
+		BattleID string `json:"battle_id"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		log.Fatalf("Failed to decode lobby result: %v", err)
+	}
+	if result.BattleID == "" {
+		fmt.Printf("Your challenge was %s.\n", result.Status)
+		os.Exit(0)
+	}
+	battleID = result.BattleID
+	fmt.Printf("Battle %s is starting!\n", battleID)
+}
+
+// List the challenges waiting for this player and accept or decline one
+func answerChallenge(defaultTeam []string) {
+	body, err := getRequest("/lobby/challenges")
+	if err != nil {
+		log.Fatalf("Failed to fetch challenges: %v", err)
+	}
+	var challenges []struct {
+		ID         string `json:"id"`
+		Challenger string `json:"challenger"`
+		Mode       string `json:"mode"`
+	}
+	if err := json.Unmarshal(body, &challenges); err != nil {
+		log.Fatalf("Failed to decode challenges: %v", err)
+	}
+	if len(challenges) == 0 {
+		fmt.Println("Nobody has challenged you.")
+		os.Exit(0)
+	}
+
+	fmt.Println("Choose a challenge:")
+	for i, c := range challenges {
+		fmt.Printf("%d. %s (%s)\n", i+1, c.Challenger, c.Mode)
+	}
+	var choice int
+	fmt.Scanln(&choice)
+	if choice < 1 || choice > len(challenges) {
+		log.Fatalf("Invalid challenge")
+	}
+	c := challenges[choice-1]
+
+	fmt.Printf("Accept %s's challenge? (yes/no):\n", c.Challenger)
 	var response string
 	fmt.Scanln(&response)
 	if strings.ToLower(response) != "yes" {
-		fmt.Println("Exiting the game.")
+		if _, err := postRequest("/lobby/challenges/"+c.ID+"/decline", nil); err != nil {
+			log.Fatalf("Failed to decline challenge: %v", err)
+		}
+		fmt.Println("Challenge declined.")
 		os.Exit(0)
 	}
 
-	// Example Pokemon setup for a new battle
+	body, err = postRequest("/lobby/challenges/"+c.ID+"/accept", LobbyRequest{Pokemon: chooseTeam(defaultTeam)})
+	if err != nil {
+		log.Fatalf("Failed to accept challenge: %v", err)
+	}
+	var battleState BattleState
+	if err := json.Unmarshal(body, &battleState); err != nil {
+		log.Fatalf("Failed to decode battle state: %v", err)
+	}
+	battleID = battleState.ID
+	fmt.Printf("Battle %s is starting!\n", battleID)
+}
+
+// Start a battle that any player, or the named opponent, can join
+func startBattle(defaultTeam []string) {
 	battleRequest := BattleRequest{
-		Player1Pokemon: []string{"Pikachu", "Charmander", "Bulbasaur"},
-		Player2Pokemon: []string{"Squirtle", "Jigglypuff", "Meowth"},
+		Player1Pokemon: chooseTeam(defaultTeam),
+		Mode:           chooseMode(),
 	}
-	_, err := postRequest("/start_battle", battleRequest)
+	fmt.Println("Opponent's name? (leave empty to let anyone join):")
+	fmt.Scanln(&battleRequest.Player2Name)
+
+	body, err := postRequest("/start_battle", battleRequest)
 	if err != nil {
 		log.Fatalf("Failed to start a new battle: %v", err)
 	}
-	fmt.Println("Battle started successfully!")
+	var battleState BattleState
+	if err := json.Unmarshal(body, &battleState); err != nil {
+		log.Fatalf("Failed to decode battle state: %v", err)
+	}
+	battleID = battleState.ID
+	fmt.Printf("Battle %s started successfully! The other player joins with this battle ID.\n", battleID)
 }
 
-func takeAction(battleState *BattleState) {
-	for {
-		// Fetch the updated battle state
-		state, err := fetchBattleState()
-		if err != nil {
-			log.Printf("Error fetching battle state: %v", err)
-			time.Sleep(2 * time.Second)
-			continue
-		}
-		*battleState = state
+// Start a battle against the computer, which plays with the chosen policy
+func battleComputer(defaultTeam []string) {
+	battleRequest := BattleRequest{
+		Player1Pokemon: chooseTeam(defaultTeam),
+		Mode:           chooseMode(),
+	}
+	fmt.Println("Computer strategy? (random/greedy/lookahead):")
+	fmt.Scanln(&battleRequest.Computer)
+	if battleRequest.Computer == "" {
+		battleRequest.Computer = "greedy"
+	}
+
+	body, err := postRequest("/start_battle", battleRequest)
+	if err != nil {
+		log.Fatalf("Failed to start a battle against the computer: %v", err)
+	}
+	var battleState BattleState
+	if err := json.Unmarshal(body, &battleState); err != nil {
+		log.Fatalf("Failed to decode battle state: %v", err)
+	}
+	battleID = battleState.ID
+	fmt.Printf("Battle %s against %s is starting!\n", battleID, battleState.Player2.Name)
+}
 
-		fmt.Printf("Current turn: %d\n", battleState.Turn)
-		fmt.Printf("Calculated turn odd: %t\n", battleState.Turn%2 == 1)
-		fmt.Printf("Calculated turn even: %t\n", battleState.Turn%2 == 0)
+// TournamentEntrant is an entrant's record in a tournament
+type TournamentEntrant struct {
+	Name       string `json:"name"`
+	Seed       int    `json:"seed"`
+	Wins       int    `json:"wins"`
+	Losses     int    `json:"losses"`
+	Byes       int    `json:"byes"`
+	Eliminated bool   `json:"eliminated"`
+}
+
+// TournamentMatch is one pairing of a tournament round, a bye when there is
+// no second player
+type TournamentMatch struct {
+	Round    int    `json:"round"`
+	Bracket  string `json:"bracket"`
+	Player1  string `json:"player1"`
+	Player2  string `json:"player2"`
+	BattleID string `json:"battle_id"`
+	Winner   string `json:"winner"`
+}
+
+type Tournament struct {
+	ID        string              `json:"id"`
+	Name      string              `json:"name"`
+	Format    string              `json:"format"`
+	Mode      string              `json:"mode"`
+	Organizer string              `json:"organizer"`
+	Status    string              `json:"status"`
+	Round     int                 `json:"round"`
+	Rounds    int                 `json:"rounds"`
+	Entrants  []TournamentEntrant `json:"entrants"`
+	Matches   []TournamentMatch   `json:"matches"`
+	Champion  string              `json:"champion"`
+}
+
+// Pick a tournament to follow, or create one, then register, start it or
+// play this player's battle in the current round
+func enterTournament(defaultTeam []string) {
+	body, err := getRequest("/tournaments")
+	if err != nil {
+		log.Fatalf("Failed to fetch tournaments: %v", err)
+	}
+	var summaries []struct {
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		Format   string `json:"format"`
+		Status   string `json:"status"`
+		Entrants int    `json:"entrants"`
+	}
+	if err := json.Unmarshal(body, &summaries); err != nil {
+		log.Fatalf("Failed to decode tournaments: %v", err)
+	}
 
-		// Check the current player's turn
-		if (battleState.Turn%2 == 1 )  {
-			playerID = "player1"
-			fmt.Printf("It's %s's turn\n", playerID)
-			fmt.Println("Choose an action (attack/defend):")
-			var action string
-			fmt.Scanln(&action)
+	fmt.Println("Choose a tournament:")
+	fmt.Println("0. Create a tournament")
+	for i, t := range summaries {
+		fmt.Printf("%d. %s (%s, %s, %d entrants)\n", i+1, t.Name, t.Format, t.Status, t.Entrants)
+	}
+	var choice int
+	fmt.Scanln(&choice)
+	if choice < 0 || choice > len(summaries) {
+		log.Fatalf("Invalid tournament")
+	}
 
-			// Send action request
-			actionRequest := ActionRequest{
-				PlayerID: playerID,
-				Action:   strings.ToLower(action),
+	var tournament Tournament
+	if choice == 0 {
+		var createRequest struct {
+			Name   string `json:"name"`
+			Format string `json:"format"`
+			Mode   string `json:"mode"`
+		}
+		fmt.Println("Tournament name? (one word, leave empty for a default):")
+		fmt.Scanln(&createRequest.Name)
+		fmt.Println("Format? (single/double/swiss):")
+		fmt.Scanln(&createRequest.Format)
+		createRequest.Mode = chooseMode()
+		body, err = postRequest("/tournaments", createRequest)
+	} else {
+		body, err = getRequest("/tournaments/" + summaries[choice-1].ID)
+	}
+	if err != nil {
+		log.Fatalf("Failed to open the tournament: %v", err)
+	}
+	if err := json.Unmarshal(body, &tournament); err != nil {
+		log.Fatalf("Failed to decode tournament: %v", err)
+	}
+	printTournament(&tournament)
+	if playTournamentBattle(&tournament) {
+		return
+	}
+
+	if tournament.Status == "registering" {
+		registered := false
+		for _, e := range tournament.Entrants {
+			registered = registered || e.Name == playerName
+		}
+		var response string
+		endpoint, request := "/start", interface{}(nil)
+		if !registered {
+			fmt.Println("Register for the tournament? (yes/no):")
+			fmt.Scanln(&response)
+			if strings.ToLower(response) == "yes" {
+				endpoint, request = "/register", LobbyRequest{Pokemon: chooseTeam(defaultTeam)}
 			}
-			response, err := postRequest("/action", actionRequest)
+		} else if tournament.Organizer == playerName {
+			fmt.Println("Start the tournament? (yes/no):")
+			fmt.Scanln(&response)
+		}
+		if strings.ToLower(response) == "yes" {
+			body, err := postRequest("/tournaments/"+tournament.ID+endpoint, request)
 			if err != nil {
-				log.Printf("Failed to send action: %v", err)
-				continue
+				log.Fatalf("Failed to update the tournament: %v", err)
+			}
+			if err := json.Unmarshal(body, &tournament); err != nil {
+				log.Fatalf("Failed to decode tournament: %v", err)
 			}
+			printTournament(&tournament)
+			if playTournamentBattle(&tournament) {
+				return
+			}
+		}
+	}
+	fmt.Println("Come back to the tournament when your next battle is ready.")
+	os.Exit(0)
+}
+
+// Pick up this player's battle if the current round is waiting on it
+func playTournamentBattle(t *Tournament) bool {
+	for _, m := range t.Matches {
+		if m.Round == t.Round && m.BattleID != "" && m.Winner == "" &&
+			(m.Player1 == playerName || m.Player2 == playerName) {
+			battleID = m.BattleID
+			fmt.Printf("Your round %d battle %s is ready!\n", m.Round, battleID)
+			return true
+		}
+	}
+	return false
+}
 
-			// Decode updated battle state after action
-			if err := json.Unmarshal(response, battleState); err != nil {
+// Draw the tournament as text: every round's matches, then the standings
+func printTournament(t *Tournament) {
+	format := strings.ReplaceAll(t.Format, "_", " ")
+	fmt.Printf("\n=== %s ===\n", t.Name)
+	fmt.Printf("%s, %s battles, run by %s (%s", format, t.Mode, t.Organizer, t.Status)
+	if t.Round > 0 {
+		fmt.Printf(", round %d", t.Round)
+		if t.Rounds > 0 {
+			fmt.Printf(" of %d", t.Rounds)
+		}
+	}
+	fmt.Println(")")
+
+	round := 0
+	for _, m := range t.Matches {
+		if m.Round != round {
+			round = m.Round
+			fmt.Printf("Round %d\n", round)
+		}
+		if m.Player2 == "" {
+			fmt.Printf("  [%s] %s has a bye\n", m.Bracket, m.Player1)
+			continue
+		}
+		outcome := "playing in battle " + m.BattleID
+		if m.Winner != "" {
+			outcome = m.Winner + " won"
+		} else if m.BattleID == "" {
+			outcome = "waiting"
+		}
+		fmt.Printf("  [%s] %s vs %s: %s\n", m.Bracket, m.Player1, m.Player2, outcome)
+	}
+
+	fmt.Println("Standings:")
+	for _, e := range t.Entrants {
+		tag := ""
+		if e.Eliminated {
+			tag = " (out)"
+		}
+		if e.Byes > 0 {
+			tag += fmt.Sprintf(" (%d byes)", e.Byes)
+		}
+		seed := ""
+		if e.Seed > 0 {
+			seed = fmt.Sprintf("#%d ", e.Seed)
+		}
+		fmt.Printf("- %s%s %d-%d%s\n", seed, e.Name, e.Wins, e.Losses, tag)
+	}
+	if t.Champion != "" {
+		fmt.Printf("Champion: %s!\n", t.Champion)
+	}
+}
+
+// Pick a battle in progress and follow it as a spectator until it ends
+func watchBattle() {
+	body, err := getRequest("/battles")
+	if err != nil {
+		log.Fatalf("Failed to fetch battles: %v", err)
+	}
+	var battles []struct {
+		ID         string `json:"id"`
+		Mode       string `json:"mode"`
+		Turn       int    `json:"turn"`
+		Player1    string `json:"player1"`
+		Player2    string `json:"player2"`
+		Spectators int    `json:"spectators"`
+	}
+	if err := json.Unmarshal(body, &battles); err != nil {
+		log.Fatalf("Failed to decode battles: %v", err)
+	}
+	if len(battles) == 0 {
+		fmt.Println("No battles are being played right now.")
+		os.Exit(0)
+	}
+
+	fmt.Println("Choose a battle to watch:")
+	for i, b := range battles {
+		fmt.Printf("%d. %s vs %s (%s, turn %d, %d watching)\n", i+1, b.Player1, b.Player2, b.Mode, b.Turn, b.Spectators)
+	}
+	var choice int
+	fmt.Scanln(&choice)
+	if choice < 1 || choice > len(battles) {
+		log.Fatalf("Invalid battle")
+	}
+	battleID = battles[choice-1].ID
+
+	events, err := subscribeBattle("spectate")
+	if err != nil {
+		log.Fatalf("Failed to watch the battle: %v", err)
+	}
+	winner := ""
+	for event := range events {
+		switch event.Type {
+		case "game_over":
+			var gameOver struct {
+				WinnerName string `json:"winner_name"`
+			}
+			if err := json.Unmarshal(event.Data, &gameOver); err == nil {
+				winner = gameOver.WinnerName
+			}
+		case "turn":
+			var view BattleState
+			if err := json.Unmarshal(event.Data, &view); err != nil {
 				log.Printf("Failed to decode battle state: %v", err)
 				continue
 			}
-			break
-		} else if (battleState.Turn%2 == 0) {
-			playerID = "player2"
-			fmt.Printf("It's %s's turn\n", playerID)
-			fmt.Println("Choose an action (attack/defend):")
-			var action string
-			fmt.Scanln(&action)
-
-			// Send action request
-			actionRequest := ActionRequest{
-				PlayerID: playerID,
-				Action:   strings.ToLower(action),
-			}
-			response, err := postRequest("/action", actionRequest)
-			if err != nil {
-				log.Printf("Failed to send action: %v", err)
-				continue
+			printEvents(view.Player1.Name, view.Player2.Name, view.Events)
+			fmt.Printf("\nTurn %d:\n", view.Turn)
+			printSideView(&view.Player1)
+			printSideView(&view.Player2)
+			if view.Status == "finished" || view.Status == "forfeited" {
+				fmt.Printf("%s wins!\n", winner)
+				os.Exit(0)
+			}
+			for _, id := range view.Chosen {
+				if id == "player1" {
+					fmt.Printf("%s has chosen.\n", view.Player1.Name)
+				} else {
+					fmt.Printf("%s has chosen.\n", view.Player2.Name)
+				}
+			}
+		}
+	}
+	log.Fatalf("Lost connection to the battle stream")
+}
+
+// Print what has been seen of one side, with the unseen Pokémon counted
+// but not named
+func printSideView(side *SideView) {
+	fmt.Printf("%s (%d of %d Pokémon left)\n", side.Name, side.Remaining, side.TeamSize)
+	for _, p := range side.Pokemon {
+		tag := ""
+		if p.Active {
+			tag = " [active]"
+		}
+		moves := ""
+		if len(p.Moves) > 0 {
+			moves = " moves: " + strings.Join(p.Moves, ", ")
+		}
+		fmt.Printf("- %s Lv.%d (HP: %d%%)%s%s%s%s\n", p.Name, p.Level, p.HPPercent, statusTag(p.Status), stagesTag(p.Stages), tag, moves)
+	}
+	if unseen := side.TeamSize - len(side.Pokemon); unseen > 0 {
+		fmt.Printf("- %d not yet seen\n", unseen)
+	}
+}
+
+// Take the second seat in the battle, unless this player already has one
+func joinBattle(battleState BattleState) {
+	if battleState.Player1.Name == playerName ||
+		(battleState.Player2.Name == playerName && battleState.Status != "pending") {
+		return
+	}
+
+	// A battle started with a team for this player is joined without one
+	var joinRequest LobbyRequest
+	if battleState.Player2.TeamSize == 0 {
+		joinRequest.Pokemon = chooseTeam([]string{"Squirtle", "Jigglypuff", "Meowth"})
+	}
+	if _, err := postRequest("/battle/"+battleID+"/join", joinRequest); err != nil {
+		log.Fatalf("Failed to join battle %s: %v", battleID, err)
+	}
+	fmt.Printf("Joined battle %s against %s!\n", battleID, battleState.Player1.Name)
+}
+
+// Narrate what the latest action or round caused. The winner is announced
+// with the final battle state.
+func printEvents(player1, player2 string, events []Event) {
+	names := map[string]string{"player1": player1, "player2": player2}
+	if len(events) > 0 {
+		fmt.Println()
+	}
+	for _, event := range events {
+		switch event.Kind {
+		case "move":
+			if event.Move == "Defend" {
+				fmt.Printf("%s chose to defend!\n", event.Pokemon)
+			} else {
+				fmt.Printf("%s used %s!\n", event.Pokemon, event.Move)
+			}
+		case "miss":
+			fmt.Println("But it missed!")
+		case "fail":
+			fmt.Println("But it failed!")
+		case "prevented":
+			switch event.Status {
+			case "sleep":
+				fmt.Printf("%s is fast asleep.\n", event.Pokemon)
+			case "freeze":
+				fmt.Printf("%s is frozen solid!\n", event.Pokemon)
+			default:
+				fmt.Printf("%s is paralyzed! It can't move!\n", event.Pokemon)
+			}
+		case "damage":
+			// The opponent's exact HP is hidden, only the share lost is known
+			amount := fmt.Sprintf("%d damage", event.Damage)
+			if event.Damage == 0 {
+				amount = fmt.Sprintf("%d%% of its HP", event.DamagePercent)
+			}
+			if event.Status != "" {
+				fmt.Printf("%s was hurt by its %s! (%s)\n", event.Pokemon, event.Status, amount)
+			} else {
+				fmt.Printf("%s took %s.\n", event.Pokemon, amount)
+			}
+		case "critical":
+			fmt.Println("A critical hit!")
+		case "effectiveness":
+			switch event.Effectiveness {
+			case "super_effective":
+				fmt.Printf("It's super effective! (x%g)\n", event.Multiplier)
+			case "not_very_effective":
+				fmt.Printf("It's not very effective... (x%g)\n", event.Multiplier)
+			case "no_effect":
+				fmt.Printf("It doesn't affect %s...\n", event.Pokemon)
+			}
+		case "status":
+			fmt.Printf("%s is now affected by %s!\n", event.Pokemon, event.Status)
+		case "cured":
+			if event.Status == "sleep" {
+				fmt.Printf("%s woke up!\n", event.Pokemon)
+			} else {
+				fmt.Printf("%s thawed out!\n", event.Pokemon)
+			}
+		case "stat_change":
+			if event.Change > 0 {
+				fmt.Printf("%s's %s rose by %d!\n", event.Pokemon, event.Stat, event.Change)
+			} else {
+				fmt.Printf("%s's %s fell by %d!\n", event.Pokemon, event.Stat, -event.Change)
+			}
+		case "faint":
+			fmt.Printf("%s's %s fainted!\n", names[event.PlayerID], event.Pokemon)
+		case "experience":
+			fmt.Printf("%s gained %d experience points!\n", event.Pokemon, event.Experience)
+			if event.Level > 0 {
+				fmt.Printf("%s grew to level %d!\n", event.Pokemon, event.Level)
+			}
+		case "switch":
+			fmt.Printf("%s withdrew %s and sent out %s!\n", names[event.PlayerID], event.Withdrawn, event.Pokemon)
+		case "forfeit":
+			fmt.Printf("%s forfeited the battle.\n", names[event.PlayerID])
+		}
+	}
+}
+
+// Format the non-zero stat stages of a Pokémon, e.g. " {attack +2, speed -1}"
+func stagesTag(stages map[string]int) string {
+	var parts []string
+	for _, stat := range []string{"attack", "defense", "special_attack", "special_defense", "speed", "accuracy", "evasion"} {
+		if stages[stat] != 0 {
+			parts = append(parts, fmt.Sprintf("%s %+d", stat, stages[stat]))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " {" + strings.Join(parts, ", ") + "}"
+}
+
+// Format a status condition for display next to HP
+func statusTag(status string) string {
+	if status == "" {
+		return ""
+	}
+	return fmt.Sprintf(" [%s]", strings.ToUpper(status))
+}
+
+// Prompt the player for an action and, when attacking or switching, which
+// move or party member to use
+func chooseAction(battleState *BattleState) ActionRequest {
+	player := &battleState.Player1
+	if playerID == "player2" {
+		player = &battleState.Player2
+	}
+
+	var actionRequest ActionRequest
+	if player.MustSwitch {
+		fmt.Printf("Choose a replacement for %s.\n", player.Team[player.CurrentPokemonIndex].Name)
+		actionRequest.Action = "switch"
+		actionRequest.Switch = chooseSwitch(player)
+		return actionRequest
+	}
+
+	fmt.Println("Choose an action (attack/defend/switch/forfeit):")
+	var action string
+	fmt.Scanln(&action)
+	actionRequest.Action = strings.ToLower(action)
+
+	switch actionRequest.Action {
+	case "attack":
+		active := player.Team[player.CurrentPokemonIndex]
+		fmt.Printf("Choose a move for %s:\n", active.Name)
+		for i, move := range active.Moves {
+			fmt.Printf("%d. %s (%s, power %d, PP %d/%d)\n", i+1, move.Name, move.Type, move.Power, move.PP, move.MaxPP)
+		}
+		var choice int
+		fmt.Scanln(&choice)
+		actionRequest.Move = choice - 1
+	case "switch":
+		actionRequest.Switch = chooseSwitch(player)
+	}
+	return actionRequest
+}
+
+// Prompt the player for the party member to send out
+func chooseSwitch(player *SideView) int {
+	fmt.Println("Choose a Pokémon to send out:")
+	for i, p := range player.Team {
+		switch {
+		case i == player.CurrentPokemonIndex:
+			fmt.Printf("%d. %s (HP: %d, in battle)\n", i+1, p.Name, p.HP)
+		case p.HP <= 0:
+			fmt.Printf("%d. %s (fainted)\n", i+1, p.Name)
+		default:
+			fmt.Printf("%d. %s (HP: %d)\n", i+1, p.Name, p.HP)
+		}
+	}
+	var choice int
+	fmt.Scanln(&choice)
+	return choice - 1
+}
+
+// An event read from the battle's Server-Sent Events stream
+type streamEvent struct {
+	Type string
+	Data []byte
+}
+
+// Open the battle's event stream and deliver its events on a channel, which
+// is closed when the stream ends
+func subscribeBattle(stream string) (<-chan streamEvent, error) {
+	req, err := http.NewRequest(http.MethodGet, serverURL+"/battle/"+battleID+"/"+stream, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+sessionToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open battle stream: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, serverError(resp.StatusCode, body)
+	}
+
+	events := make(chan streamEvent)
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+
+		var event streamEvent
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event.Type = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.Data = []byte(strings.TrimPrefix(line, "data: "))
+			case line == "" && event.Type != "":
+				events <- event
+				event = streamEvent{}
 			}
+		}
+	}()
+	return events, nil
+}
 
-			// Decode updated battle state after action
-			if err := json.Unmarshal(response, battleState); err != nil {
+// Wait for the next battle state on the stream, noting the end of the battle
+// as it arrives. It returns the winner's name once the battle is over.
+func waitForUpdate(events <-chan streamEvent) (BattleState, string) {
+	var winner string
+	for event := range events {
+		switch event.Type {
+		case "game_over":
+			var gameOver struct {
+				WinnerName string `json:"winner_name"`
+			}
+			if err := json.Unmarshal(event.Data, &gameOver); err == nil {
+				winner = gameOver.WinnerName
+			}
+		case "turn":
+			var battleState BattleState
+			if err := json.Unmarshal(event.Data, &battleState); err != nil {
 				log.Printf("Failed to decode battle state: %v", err)
 				continue
 			}
-			break
+			return battleState, winner
+		}
+	}
+	log.Fatalf("Lost connection to the battle stream")
+	return BattleState{}, ""
+}
+
+// Report whether the battle is waiting on this player: for a replacement
+// after a faint, or for their action this turn or round
+func isMyMove(battleState *BattleState) bool {
+	me, opponent := &battleState.Player1, &battleState.Player2
+	if playerID == "player2" {
+		me, opponent = opponent, me
+	}
+	if me.MustSwitch {
+		return true
+	}
+	if opponent.MustSwitch {
+		return false
+	}
+	if battleState.Mode == "simultaneous" {
+		for _, id := range battleState.Chosen {
+			if id == playerID {
+				return false
+			}
+		}
+		return true
+	}
+	return (battleState.Turn%2 == 1) == (playerID == "player1")
+}
+
+// Choose and send this player's action until the server accepts it. The
+// result arrives on the battle stream.
+func takeAction(battleState *BattleState) {
+	for {
+		if battleState.Mode == "simultaneous" {
+			fmt.Printf("Round %d: choose your action, %s%s\n", battleState.Turn, playerName, timeLeftTag(battleState))
 		} else {
-			fmt.Println("Waiting for the other player to make a move...")
-			time.Sleep(2 * time.Second) 
+			fmt.Printf("Turn %d: it's your turn, %s%s\n", battleState.Turn, playerName, timeLeftTag(battleState))
+		}
+		actionRequest := chooseAction(battleState)
+		if battleState.Mode == "simultaneous" {
+			fmt.Println("Waiting for the other player to choose...")
 		}
+		if _, err := postRequest("/battle/"+battleID+"/action", actionRequest); err != nil {
+			log.Printf("Failed to send action: %v", err)
+
+			// Choose again unless the clock ran out and the server already
+			// acted for this player
+			state, fetchErr := fetchBattleState()
+			if fetchErr != nil || state.Turn != battleState.Turn || !isMyMove(&state) ||
+				state.Status != "active" {
+				return
+			}
+			*battleState = state
+			continue
+		}
+		return
 	}
 }
 
+// Format the time left to choose, e.g. " (45 seconds left)"
+func timeLeftTag(battleState *BattleState) string {
+	if battleState.TimeLeft <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%d seconds left)", battleState.TimeLeft)
+}
+
 func main() {
-	// Determine player ID
+	// Log in with the player's name
 	if len(os.Args) < 2 {
-		log.Fatalf("Usage: go run main.go [player1|player2]")
+		log.Fatalf("Usage: go run main.go <player-name> [battle-id]")
 	}
-	playerID = strings.ToLower(os.Args[1])
-	if playerID != "player1" && playerID != "player2" {
-		log.Fatalf("Invalid player ID. Use 'player1' or 'player2'")
+	playerName = os.Args[1]
+	login(playerName)
+	if len(os.Args) > 2 {
+		battleID = os.Args[2]
 	}
 
-	// Fetch the initial battle state
-	battleState, err := fetchBattleState()
-	if err != nil {
+	// Join the given battle, or find one in the lobby if none was given
+	if battleState, err := fetchBattleState(); err != nil {
 		fmt.Println(err.Error())
-		startBattle() // Start a new battle if none exists
-		battleState, err = fetchBattleState()
-		if err != nil {
-			log.Fatalf("Failed to fetch battle state after starting a new battle: %v", err)
-		}
+		enterLobby()
+	} else {
+		joinBattle(battleState)
+	}
+
+	// The stream opens with the current battle state
+	events, err := subscribeBattle("events")
+	if err != nil {
+		log.Fatalf("Failed to follow the battle: %v", err)
+	}
+	battleState, winner := waitForUpdate(events)
+	playerID = "player1"
+	if battleState.Player2.Name == playerName {
+		playerID = "player2"
+	}
+
+	// The battle begins once the opponent has joined with their team
+	for battleState.Status == "pending" {
+		fmt.Printf("Waiting for an opponent to join battle %s...\n", battleID)
+		battleState, winner = waitForUpdate(events)
 	}
 
 	// Game loop
 	for {
-		fmt.Printf("\nBattle State:\n")
-		fmt.Printf("Player 1: %s\n", battleState.Player1.Name)
-		for _, p := range battleState.Player1.Pokemon {
-			fmt.Printf("- %s (HP: %d)\n", p.Name, p.HP)
+		printEvents(battleState.Player1.Name, battleState.Player2.Name, battleState.Events)
+
+		// Your own team in full, the opponent's only as far as it has been
+		// revealed
+		me, opponent := &battleState.Player1, &battleState.Player2
+		if playerID == "player2" {
+			me, opponent = opponent, me
 		}
-		fmt.Printf("Player 2: %s\n", battleState.Player2.Name)
-		for _, p := range battleState.Player2.Pokemon {
-			fmt.Printf("- %s (HP: %d)\n", p.Name, p.HP)
+		fmt.Printf("\nBattle State:\n")
+		fmt.Printf("You: %s\n", me.Name)
+		for _, p := range me.Team {
+			fmt.Printf("- %s Lv.%d (HP: %d/%d)%s%s\n", p.Name, p.Level, p.HP, p.MaxHP, statusTag(p.Status), stagesTag(p.Stages))
 		}
+		fmt.Print("Opponent: ")
+		printSideView(opponent)
 
 		// Check for winner
-		allFainted1 := true
-		allFainted2 := true
-		for _, p := range battleState.Player1.Pokemon {
-			if p.HP > 0 {
-				allFainted1 = false
-				break
+		if battleState.Status == "finished" || battleState.Status == "forfeited" {
+			if winner == "" {
+				winner = battleState.Player1.Name
+				if battleState.Winner == "player2" {
+					winner = battleState.Player2.Name
+				}
 			}
-		}
-		for _, p := range battleState.Player2.Pokemon {
-			if p.HP > 0 {
-				allFainted2 = false
-				break
-			}
-		}
-
-		if allFainted1 {
-			fmt.Println("Player 2 wins!")
-			break
-		} else if allFainted2 {
-			fmt.Println("Player 1 wins!")
+			fmt.Printf("%s wins!\n", winner)
 			break
 		}
 
-		takeAction(&battleState)
+		if isMyMove(&battleState) {
+			takeAction(&battleState)
+		} else {
+			fmt.Println("Waiting for the other player to make a move...")
+		}
+		battleState, winner = waitForUpdate(events)
 	}
 }
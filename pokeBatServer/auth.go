@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"netcentric/gameplay"
+	"netcentric/storage"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxPlayerNameLength = 20
+	minPasswordLength   = 6
+)
+
+// Setting the token secret is kept under when POKEBAT_SECRET isn't set
+const tokenSecretSetting = "token_secret"
+
+// tokenLifetime is how long a session token is good for. Players log in
+// again with their password for a new one.
+const tokenLifetime = 7 * 24 * time.Hour
+
+// passwordIterations is how many rounds of PBKDF2 a password is hashed with
+var passwordIterations = 100_000
+
+// sessionClaims is what a session token vouches for.
+type sessionClaims struct {
+	Name   string `json:"name"`
+	Issued int64  `json:"issued"`
+}
+
+// tokenSecret signs session tokens. It is loaded when the server starts.
+var tokenSecret []byte
+
+var (
+	errMissingToken     = errors.New("missing session token")
+	errNotParticipant   = errors.New("you are not a player in this battle")
+	errWrongCredentials = errors.New("wrong name or password")
+	errExpiredToken     = errors.New("session token expired, log in again")
+)
+
+// loadTokenSecret returns POKEBAT_SECRET if it is set, and otherwise the
+// secret kept in the store, made the first time the server runs, so tokens
+// stay valid across restarts either way.
+func loadTokenSecret(store storage.Store) []byte {
+	if secret := os.Getenv("POKEBAT_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	var secret []byte
+	err := store.Update(func(tx storage.Tx) error {
+		err := tx.Get(storage.Settings, tokenSecretSetting, &secret)
+		if !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		return tx.Put(storage.Settings, tokenSecretSetting, secret)
+	})
+	if err != nil {
+		log.Fatalf("Failed to load token secret: %v", err)
+	}
+	return secret
+}
+
+// pbkdf2 derives a 32-byte key from a password with PBKDF2-HMAC-SHA256.
+func pbkdf2(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	key := slices.Clone(u)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// hashPassword salts and hashes a password for storing, in the form
+// iterations$salt$key with the salt and key base64url encoded.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2([]byte(password), salt, passwordIterations)
+	return fmt.Sprintf("%d$%s$%s", passwordIterations, base64.RawURLEncoding.EncodeToString(salt),
+		base64.RawURLEncoding.EncodeToString(key)), nil
+}
+
+// checkPassword reports whether the password matches a stored hash.
+func checkPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 3 {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[0])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(key, pbkdf2([]byte(password), salt, iterations))
+}
+
+func signToken(payload string) string {
+	mac := hmac.New(sha256.New, tokenSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issueToken returns a signed session token for the player, in the form
+// payload.signature with both halves base64url encoded.
+func issueToken(name string) (string, error) {
+	claims, err := json.Marshal(sessionClaims{Name: name, Issued: time.Now().Unix()})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + signToken(payload), nil
+}
+
+// verifyToken checks a session token's signature and returns its claims.
+func verifyToken(token string) (sessionClaims, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signToken(payload))) {
+		return sessionClaims{}, errors.New("invalid session token")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return sessionClaims{}, errors.New("invalid session token")
+	}
+	var claims sessionClaims
+	if err := json.Unmarshal(data, &claims); err != nil || claims.Name == "" {
+		return sessionClaims{}, errors.New("invalid session token")
+	}
+	if time.Since(time.Unix(claims.Issued, 0)) > tokenLifetime {
+		return sessionClaims{}, errExpiredToken
+	}
+	return claims, nil
+}
+
+// authenticate returns the name of the player whose bearer token came with
+// the request, answering 401 when there is no valid token.
+func authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name, err := sessionName(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return "", false
+	}
+	return name, true
+}
+
+// sessionName returns the name of the player whose bearer token came with
+// the request, or why there is none.
+func sessionName(r *http.Request) (string, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", errMissingToken
+	}
+	claims, err := verifyToken(token)
+	if err != nil {
+		return "", err
+	}
+	return claims.Name, nil
+}
+
+// playerID returns which side of the battle the named player is on. The
+// caller holds the room's lock.
+func (room *battleRoom) playerID(name string) (string, error) {
+	switch {
+	case name == room.battle.Player1.Name:
+		return room.battle.Player1.ID, nil
+	case room.battle.Status != gameplay.BattlePending && name == room.battle.Player2.Name:
+		return room.battle.Player2.ID, nil
+	}
+	return "", errNotParticipant
+}
+
+// Handle registering a player name and issuing its session token
+func handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var registerRequest struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&registerRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(registerRequest.Name)
+	if name == "" || len(name) > maxPlayerNameLength {
+		http.Error(w, fmt.Sprintf("Name must be 1 to %d characters", maxPlayerNameLength), http.StatusBadRequest)
+		return
+	}
+	if isComputerName(name) {
+		http.Error(w, "That name is kept for the computer", http.StatusBadRequest)
+		return
+	}
+	if len(registerRequest.Password) < minPasswordLength {
+		http.Error(w, fmt.Sprintf("Password must be at least %d characters", minPasswordLength), http.StatusBadRequest)
+		return
+	}
+
+	// The name is only taken once the password hash is stored with it, so
+	// one player can't be handed a token for another's name
+	hash, err := hashPassword(registerRequest.Password)
+	if err == nil {
+		err = storage.RegisterPlayer(db, name, hash)
+	}
+	if errors.Is(err, storage.ErrExists) {
+		http.Error(w, fmt.Sprintf("Name %s is already registered", name), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to register player %s: %v", name, err)
+		http.Error(w, "Failed to register player", http.StatusInternalServerError)
+		return
+	}
+	token, err := issueToken(name)
+	if err != nil {
+		http.Error(w, "Failed to issue session token", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Registered player %s", name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"name": name, "token": token})
+}
+
+// Handle a registered player logging in again for a new session token, after
+// losing theirs or once it has expired
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var loginRequest struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&loginRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(loginRequest.Name)
+
+	// Unknown names get the same answer as wrong passwords
+	player, err := storage.LookupPlayer(db, name)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Failed to look up player %s: %v", name, err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	if err != nil || !checkPassword(player.PasswordHash, loginRequest.Password) {
+		http.Error(w, errWrongCredentials.Error(), http.StatusUnauthorized)
+		return
+	}
+	token, err := issueToken(name)
+	if err != nil {
+		http.Error(w, "Failed to issue session token", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Player %s logged in", name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"name": name, "token": token})
+}
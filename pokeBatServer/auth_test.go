@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestExpiredToken(t *testing.T) {
+	claims, err := json.Marshal(sessionClaims{Name: "expired", Issued: time.Now().Add(-tokenLifetime - time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	if _, err := verifyToken(payload + "." + signToken(payload)); !errors.Is(err, errExpiredToken) {
+		t.Errorf("Old token got %v, want %v", err, errExpiredToken)
+	}
+
+	token, err := issueToken("fresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := verifyToken(token); err != nil || claims.Name != "fresh" {
+		t.Errorf("New token got %+v, %v", claims, err)
+	}
+}
+
+func TestLoginAgain(t *testing.T) {
+	server := newTestServer(t)
+	register(t, server, "returning")
+
+	for _, password := range []string{"wrong password", ""} {
+		if status, body := post(t, server.URL+"/login", "", map[string]string{"name": "returning", "password": password}); status != http.StatusUnauthorized {
+			t.Errorf("Logging in with %q got %d %s", password, status, body)
+		}
+	}
+	if status, body := post(t, server.URL+"/login", "", map[string]string{"name": "nobody", "password": "password"}); status != http.StatusUnauthorized {
+		t.Errorf("Logging in as an unknown player got %d %s", status, body)
+	}
+
+	status, body := post(t, server.URL+"/login", "", map[string]string{"name": "returning", "password": "password"})
+	if status != http.StatusOK {
+		t.Fatalf("Logging in got %d %s", status, body)
+	}
+	var session struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal([]byte(body), &session); err != nil {
+		t.Fatalf("Failed to decode session: %v", err)
+	}
+	if claims, err := verifyToken(session.Token); err != nil || claims.Name != "returning" {
+		t.Errorf("Login token got %+v, %v", claims, err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"netcentric/gameplay"
+	"netcentric/utils"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// computerNamePrefix starts the name of every computer opponent. Players
+// can't register names with it, so nobody can take the computer's seat.
+const computerNamePrefix = "Computer ("
+
+// How many random teams are tried before giving up on finding one that
+// passes the team rules
+const computerTeamAttempts = 20
+
+// computerPlayer is a seat played by the server, choosing with one of the
+// gameplay policies.
+type computerPlayer struct {
+	playerID string
+	policy   gameplay.Policy
+	rng      *rand.Rand
+}
+
+func computerName(policy string) string {
+	return computerNamePrefix + strings.ToLower(policy) + ")"
+}
+
+func isComputerName(name string) bool {
+	return strings.HasPrefix(name, computerNamePrefix)
+}
+
+// newComputerPlayer looks up the policy the computer plays the seat with. Its
+// random source is seeded from the battle's seed, but kept apart from the
+// battle's own rolls, so the same seed brings the same choices.
+func newComputerPlayer(playerID, policyName string, seed int64) (*computerPlayer, error) {
+	policy, err := gameplay.LookupPolicy(policyName)
+	if err != nil {
+		return nil, err
+	}
+	return &computerPlayer{playerID: playerID, policy: policy, rng: rand.New(rand.NewSource(seed + 1))}, nil
+}
+
+// randomComputerTeam picks a team of the given size that passes the team
+// rules, so the computer never brings a banned Pokémon.
+func randomComputerTeam(size int, rng *rand.Rand) ([]gameplay.Pokemon, error) {
+	size = max(teamRules.MinTeamSize, min(size, teamRules.MaxTeamSize))
+	names := make([]string, 0, len(utils.PokeMap))
+	for name := range utils.PokeMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for attempt := 0; attempt < computerTeamAttempts; attempt++ {
+		members := make([]TeamMember, size)
+		for i := range members {
+			members[i] = TeamMember{Name: names[rng.Intn(len(names))]}
+		}
+		if team, _, problems := buildValidTeam("", "computer", members); len(problems) == 0 {
+			return team, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to pick a team for the computer")
+}
+
+// act carries out a choice the server makes for a player, whichever kind of
+// choice the battle is waiting on. The caller holds the room's lock.
+func (room *battleRoom) act(action gameplay.Action) error {
+	switch {
+	case room.closed:
+		return errBattleClosed
+	case action.Action == "forfeit":
+		return room.forfeit(action.PlayerID)
+	case gameplay.NeedsReplacement(room.battle, action.PlayerID):
+		return room.replace(action)
+	case room.battle.Mode == gameplay.ModeSimultaneous:
+		_, err := room.submitRound(action)
+		return err
+	}
+	return room.takeTurn(action)
+}
+
+// playComputer makes the computer's choice if the battle is waiting on it,
+// falling back to the default action if the policy picks one the battle
+// won't take. The caller holds the room's lock.
+func (room *battleRoom) playComputer() {
+	computer := room.computer
+	if computer == nil || room.battle.Status != gameplay.BattleActive ||
+		!slices.Contains(room.waitingOn(), computer.playerID) {
+		return
+	}
+
+	action := computer.policy(room.battle, computer.playerID, computer.rng)
+	if err := room.act(action); err != nil {
+		log.Printf("Battle %s: the computer's %s was refused: %v", room.battle.ID, action.Action, err)
+		if err := room.act(gameplay.DefaultAction(room.battle, computer.playerID)); err != nil {
+			log.Printf("Battle %s: the computer could not act: %v", room.battle.ID, err)
+		}
+	}
+}
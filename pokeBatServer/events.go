@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"netcentric/gameplay"
+)
+
+// Event types pushed on a battle's stream.
+const (
+	eventTurn     = "turn"      // the battle state after an action, round or replacement
+	eventFaint    = "faint"     // a Pokémon fainted
+	eventGameOver = "game_over" // the battle has a winner
+)
+
+// Events a subscriber hasn't read yet; a subscriber that falls this far
+// behind is dropped rather than holding up the battle.
+const eventBuffer = 32
+
+// battleEvent is one Server-Sent Event, encoded when it is published so
+// subscribers never touch the battle itself.
+type battleEvent struct {
+	Type string
+	Data []byte
+}
+
+type faintEvent struct {
+	PlayerID string `json:"player_id"`
+	Pokemon  string `json:"pokemon"`
+}
+
+type gameOverEvent struct {
+	Status     string `json:"status"`
+	Winner     string `json:"winner"`
+	WinnerName string `json:"winner_name"`
+}
+
+// spectator is the viewer a spectator's stream is subscribed as, where a
+// player's stream has their player ID.
+const spectator = ""
+
+// subscribe registers a new stream for the room's events, as seen by the
+// viewer. The caller holds the room's lock.
+func (room *battleRoom) subscribe(viewer string) chan battleEvent {
+	events := make(chan battleEvent, eventBuffer)
+	if room.subscribers == nil {
+		room.subscribers = make(map[chan battleEvent]string)
+	}
+	room.subscribers[events] = viewer
+	return events
+}
+
+// unsubscribe removes a stream and closes it. The caller holds the room's lock.
+func (room *battleRoom) unsubscribe(events chan battleEvent) {
+	if _, ok := room.subscribers[events]; ok {
+		delete(room.subscribers, events)
+		close(events)
+	}
+}
+
+// spectators counts the streams watching without a seat. The caller holds
+// the room's lock.
+func (room *battleRoom) spectators() int {
+	count := 0
+	for _, viewer := range room.subscribers {
+		if viewer == spectator {
+			count++
+		}
+	}
+	return count
+}
+
+// view returns the battle state as the viewer may see it: players get their
+// own side in full, and only what has been revealed of the rest. The caller
+// holds the room's lock.
+func (room *battleRoom) view(viewer string) gameplay.BattleView {
+	if viewer == spectator {
+		return gameplay.SpectatorView(room.state())
+	}
+	return gameplay.PlayerView(room.state(), viewer)
+}
+
+// send queues an event on a stream, dropping the subscriber if it has fallen
+// too far behind. The caller holds the room's lock.
+func (room *battleRoom) send(events chan battleEvent, event battleEvent) {
+	select {
+	case events <- event:
+	default:
+		log.Printf("Dropping slow subscriber from battle %s", room.battle.ID)
+		room.unsubscribe(events)
+	}
+}
+
+// publish pushes an event every viewer may see in full.
+func (room *battleRoom) publish(eventType string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode %s event for battle %s: %v", eventType, room.battle.ID, err)
+		return
+	}
+	for events := range room.subscribers {
+		room.send(events, battleEvent{Type: eventType, Data: data})
+	}
+}
+
+// publishState pushes the battle state to every stream, encoded once for
+// each kind of viewer. The caller holds the room's lock.
+func (room *battleRoom) publishState() {
+	encoded := make(map[string][]byte)
+	for events, viewer := range room.subscribers {
+		data, ok := encoded[viewer]
+		if !ok {
+			var err error
+			if data, err = json.Marshal(room.view(viewer)); err != nil {
+				log.Printf("Failed to encode %s event for battle %s: %v", eventTurn, room.battle.ID, err)
+				continue
+			}
+			encoded[viewer] = data
+		}
+		room.send(events, battleEvent{Type: eventTurn, Data: data})
+	}
+}
+
+// publishUpdate pushes everything that changed since the last update: newly
+// fainted Pokémon, the end of the battle, then the new battle state. The
+// caller holds the room's lock.
+func (room *battleRoom) publishUpdate() {
+	battle := room.battle
+	if room.fainted == nil {
+		room.fainted = make(map[string]bool)
+	}
+	for _, player := range []*gameplay.Player{&battle.Player1, &battle.Player2} {
+		for i, pokemon := range player.Pokemon {
+			key := fmt.Sprintf("%s/%d", player.ID, i)
+			if pokemon.HP > 0 || room.fainted[key] {
+				continue
+			}
+			room.fainted[key] = true
+			room.publish(eventFaint, faintEvent{PlayerID: player.ID, Pokemon: pokemon.Name})
+		}
+	}
+
+	if battle.IsOver() && !room.gameOverSent {
+		room.gameOverSent = true
+		winnerName := battle.Player1.Name
+		if battle.Winner == battle.Player2.ID {
+			winnerName = battle.Player2.Name
+		}
+		room.publish(eventGameOver, gameOverEvent{Status: battle.Status, Winner: battle.Winner, WinnerName: winnerName})
+	}
+
+	room.publishState()
+}
+
+// Handle a player's Server-Sent Events stream of battle updates GET method
+func handleBattleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+	room, ok := findRoom(w, r)
+	if !ok {
+		return
+	}
+	room.mu.Lock()
+	playerID, err := room.playerID(name)
+	room.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	streamBattle(w, r, room, playerID)
+}
+
+// streamBattle sends the battle's events to the viewer until the battle is
+// dropped or the viewer goes away.
+func streamBattle(w http.ResponseWriter, r *http.Request, room *battleRoom, viewer string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Start the stream with the current state so nothing is missed between
+	// fetching the battle and subscribing
+	room.mu.Lock()
+	if room.closed {
+		room.mu.Unlock()
+		http.Error(w, errBattleClosed.Error(), http.StatusNotFound)
+		return
+	}
+	events := room.subscribe(viewer)
+	current, err := json.Marshal(room.view(viewer))
+	room.mu.Unlock()
+	defer func() {
+		room.mu.Lock()
+		room.unsubscribe(events)
+		room.mu.Unlock()
+	}()
+	if err != nil {
+		http.Error(w, "Failed to encode battle state", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	writeEvent(w, battleEvent{Type: eventTurn, Data: current})
+	flusher.Flush()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event battleEvent) {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, event.Data)
+}
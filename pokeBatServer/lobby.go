@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"netcentric/gameplay"
+	"sync"
+	"time"
+)
+
+// Outcomes reported to players waiting in the lobby.
+const (
+	lobbyMatched  = "matched"
+	lobbyAccepted = "accepted"
+	lobbyDeclined = "declined"
+)
+
+// lobbyResult tells a waiting player how their queue entry or challenge
+// turned out.
+type lobbyResult struct {
+	Status   string `json:"status"`
+	BattleID string `json:"battle_id,omitempty"`
+}
+
+// queueEntry is a player waiting to be paired, with their team already raised
+// for battle.
+type queueEntry struct {
+	name   string
+	mode   string
+	team   []gameplay.Pokemon
+	keys   []string
+	queued time.Time
+	result chan lobbyResult
+}
+
+// challenge is a direct invitation from one player to another.
+type challenge struct {
+	ID         string    `json:"id"`
+	Challenger string    `json:"challenger"`
+	Opponent   string    `json:"opponent"`
+	Mode       string    `json:"mode"`
+	Created    time.Time `json:"created"`
+
+	team   []gameplay.Pokemon
+	keys   []string
+	result chan lobbyResult
+}
+
+// lobby pairs queued players and keeps the open challenges.
+type lobby struct {
+	mu         sync.Mutex
+	queue      []*queueEntry
+	challenges map[string]*challenge
+}
+
+var matchmaking = &lobby{challenges: make(map[string]*challenge)}
+
+// choosePartner picks which waiting entry the new entry is paired with, or
+// -1 to keep waiting. Every candidate has the same mode and a different
+// player. The default pairs the player with the closest rating, the one
+// who has waited longest among equals.
+var choosePartner = func(entry *queueEntry, candidates []*queueEntry) int {
+	pick, closest := -1, 0
+	rating := ratings.rating(entry.name)
+	for i, candidate := range candidates {
+		gap := ratings.rating(candidate.name) - rating
+		if gap < 0 {
+			gap = -gap
+		}
+		if pick < 0 || gap < closest {
+			pick, closest = i, gap
+		}
+	}
+	return pick
+}
+
+// startMatchedBattle opens a battle between two players who both brought a
+// team, so it begins straight away.
+func startMatchedBattle(mode string, player1, player2 gameplay.Player, keys1, keys2 []string) *battleRoom {
+	player1.ID, player2.ID = "player1", "player2"
+	room := rooms.add(&gameplay.Battle{
+		Player1: player1,
+		Player2: player2,
+		Turn:    1,
+		Mode:    mode,
+		Seed:    time.Now().UnixNano(),
+	}, map[string][]string{player1.ID: keys1, player2.ID: keys2}, true)
+	log.Printf("Started battle %s: %s vs %s", room.battle.ID, player1.Name, player2.Name)
+	return room
+}
+
+// enqueue adds the entry to the queue, or pairs it straight away with a
+// waiting player of the same mode.
+func (l *lobby) enqueue(entry *queueEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var candidates []*queueEntry
+	for _, waiting := range l.queue {
+		if waiting.name == entry.name {
+			return fmt.Errorf("%s is already in the queue", entry.name)
+		}
+		if waiting.mode == entry.mode {
+			candidates = append(candidates, waiting)
+		}
+	}
+
+	pick := choosePartner(entry, candidates)
+	if pick < 0 || pick >= len(candidates) {
+		l.queue = append(l.queue, entry)
+		return nil
+	}
+	partner := candidates[pick]
+	l.removeLocked(partner)
+
+	room := startMatchedBattle(entry.mode,
+		gameplay.Player{Name: partner.name, Pokemon: partner.team},
+		gameplay.Player{Name: entry.name, Pokemon: entry.team},
+		partner.keys, entry.keys)
+	result := lobbyResult{Status: lobbyMatched, BattleID: room.battle.ID}
+	partner.result <- result
+	entry.result <- result
+	return nil
+}
+
+// leave takes the entry out of the queue if it is still waiting.
+func (l *lobby) leave(entry *queueEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.removeLocked(entry)
+}
+
+func (l *lobby) removeLocked(entry *queueEntry) {
+	for i, waiting := range l.queue {
+		if waiting == entry {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// waitForResult blocks until the lobby answers or the player gives up, in
+// which case cancel is called.
+func waitForResult(w http.ResponseWriter, r *http.Request, result chan lobbyResult, cancel func()) {
+	select {
+	case res := <-result:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	case <-r.Context().Done():
+		cancel()
+	}
+}
+
+// Handle a player entering the matchmaking queue. The request is answered
+// once they have been paired.
+func handleQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var queueRequest struct {
+		Pokemon []TeamMember `json:"pokemon"`
+		Mode    string       `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&queueRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	mode, err := battleMode(queueRequest.Mode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	team, keys, problems := buildValidTeam(name, "pokemon", queueRequest.Pokemon)
+	if len(problems) > 0 {
+		writeTeamProblems(w, problems)
+		return
+	}
+
+	entry := &queueEntry{
+		name:   name,
+		mode:   mode,
+		team:   team,
+		keys:   keys,
+		queued: time.Now(),
+		result: make(chan lobbyResult, 1),
+	}
+	log.Printf("%s is looking for a %s battle", name, mode)
+	if err := matchmaking.enqueue(entry); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	waitForResult(w, r, entry.result, func() {
+		matchmaking.leave(entry)
+	})
+}
+
+// Handle challenges: POST issues one to a named player and is answered once
+// they accept or decline, GET lists the challenges waiting for the player
+func handleChallenges(w http.ResponseWriter, r *http.Request) {
+	name, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(matchmaking.challengesFor(name))
+	case http.MethodPost:
+		issueChallenge(w, r, name)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func issueChallenge(w http.ResponseWriter, r *http.Request, name string) {
+	var challengeRequest struct {
+		Opponent string       `json:"opponent"`
+		Pokemon  []TeamMember `json:"pokemon"`
+		Mode     string       `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&challengeRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if challengeRequest.Opponent == "" || challengeRequest.Opponent == name {
+		http.Error(w, "Choose another player to challenge", http.StatusBadRequest)
+		return
+	}
+	mode, err := battleMode(challengeRequest.Mode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	team, keys, problems := buildValidTeam(name, "pokemon", challengeRequest.Pokemon)
+	if len(problems) > 0 {
+		writeTeamProblems(w, problems)
+		return
+	}
+
+	c := &challenge{
+		ID:         newID(),
+		Challenger: name,
+		Opponent:   challengeRequest.Opponent,
+		Mode:       mode,
+		Created:    time.Now(),
+		team:       team,
+		keys:       keys,
+		result:     make(chan lobbyResult, 1),
+	}
+	matchmaking.mu.Lock()
+	matchmaking.challenges[c.ID] = c
+	matchmaking.mu.Unlock()
+	log.Printf("%s challenged %s to a %s battle", c.Challenger, c.Opponent, mode)
+
+	waitForResult(w, r, c.result, func() {
+		matchmaking.take(c.ID, c.Opponent)
+	})
+}
+
+// challengesFor lists the open challenges issued to the player.
+func (l *lobby) challengesFor(name string) []*challenge {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	open := []*challenge{}
+	for _, c := range l.challenges {
+		if c.Opponent == name {
+			open = append(open, c)
+		}
+	}
+	return open
+}
+
+// take removes a challenge issued to the player so it can be answered.
+func (l *lobby) take(id, opponent string) (*challenge, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.challenges[id]
+	if !ok || c.Opponent != opponent {
+		return nil, false
+	}
+	delete(l.challenges, id)
+	return c, true
+}
+
+// Handle accepting a challenge with the opponent's team, which starts the battle
+func handleAcceptChallenge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var acceptRequest struct {
+		Pokemon []TeamMember `json:"pokemon"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&acceptRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	team, keys, problems := buildValidTeam(name, "pokemon", acceptRequest.Pokemon)
+	if len(problems) > 0 {
+		writeTeamProblems(w, problems)
+		return
+	}
+
+	c, ok := matchmaking.take(r.PathValue("id"), name)
+	if !ok {
+		http.Error(w, "Challenge not found", http.StatusNotFound)
+		return
+	}
+	room := startMatchedBattle(c.Mode,
+		gameplay.Player{Name: c.Challenger, Pokemon: c.team},
+		gameplay.Player{Name: name, Pokemon: team},
+		c.keys, keys)
+	c.result <- lobbyResult{Status: lobbyAccepted, BattleID: room.battle.ID}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room.view(room.battle.Player2.ID))
+}
+
+// Handle turning down a challenge
+func handleDeclineChallenge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+	c, ok := matchmaking.take(r.PathValue("id"), name)
+	if !ok {
+		http.Error(w, "Challenge not found", http.StatusNotFound)
+		return
+	}
+	c.result <- lobbyResult{Status: lobbyDeclined}
+	log.Printf("%s declined %s's challenge", name, c.Challenger)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lobbyResult{Status: lobbyDeclined})
+}
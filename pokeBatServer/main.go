@@ -2,15 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"netcentric/gameplay"
 	"strings"
-	"netcentric/utils"
+	"time"
 )
 
-var battle *gameplay.Battle
-
 // Handle the battle requests (starting a battle)
 func handleBattleRequest(w http.ResponseWriter, r *http.Request) {
 	// Ensure method is POST
@@ -19,59 +18,288 @@ func handleBattleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Decode the incoming battle request
+	// The player starting the battle takes the first seat
+	name, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	// Decode the incoming battle request. The second seat can be reserved
+	// for a named opponent, and their team is left to them when they join.
 	var battleRequest struct {
-		Player1Pokemon []string `json:"player1_pokemon"`
-		Player2Pokemon []string `json:"player2_pokemon"`
+		Player1Pokemon []TeamMember `json:"player1_pokemon"`
+		Player2Pokemon []TeamMember `json:"player2_pokemon"`
+		Player2Name    string       `json:"player2_name"`
+		Computer       string       `json:"computer"` // a policy for a computer opponent
+		Mode           string       `json:"mode"`
+		Seed           int64        `json:"seed"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&battleRequest); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Initialize Players
-	player1 := gameplay.Player{ID: "player1", Name: "Player 1", Pokemon: make([]gameplay.Pokemon, 3)}
-	player2 := gameplay.Player{ID: "player2", Name: "Player 2", Pokemon: make([]gameplay.Pokemon, 3)}
+	mode, err := battleMode(battleRequest.Mode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	seed := battleRequest.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	if battleRequest.Player2Name == name {
+		http.Error(w, "You can't battle yourself", http.StatusBadRequest)
+		return
+	}
 
-	// Fetch Pokémon data based on player selection
-	for i, pkmnName := range battleRequest.Player1Pokemon {
-		number, _ := utils.PokeMap[strings.Title(strings.ToLower(pkmnName))]
-		pokemon, _ := gameplay.ReadPokemonData(number)
-		player1.Pokemon[i] = pokemon
+	// The computer takes the second seat when a policy is given for it
+	var computer *computerPlayer
+	if battleRequest.Computer != "" {
+		if battleRequest.Player2Name != "" {
+			http.Error(w, "Choose either an opponent or the computer", http.StatusBadRequest)
+			return
+		}
+		if computer, err = newComputerPlayer("player2", battleRequest.Computer, seed); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		battleRequest.Player2Name = computerName(battleRequest.Computer)
 	}
 
-	for i, pkmnName := range battleRequest.Player2Pokemon {
-		number, _ := utils.PokeMap[strings.Title(strings.ToLower(pkmnName))]
-		pokemon, _ := gameplay.ReadPokemonData(number) 
-		player2.Pokemon[i] = pokemon
+	// Initialize Players. Named players battle with their trained Pokémon
+	// and keep their progress.
+	player1 := gameplay.Player{ID: "player1", Name: name}
+	player2 := gameplay.Player{ID: "player2", Name: battleRequest.Player2Name}
+	rosterKeys := map[string][]string{}
+
+	// Fetch Pokémon data based on player selection, checking both teams
+	// against the rules before answering
+	var problems, player2Problems []teamProblem
+	player1.Pokemon, rosterKeys[player1.ID], problems = buildValidTeam(player1.Name, "player1_pokemon", battleRequest.Player1Pokemon)
+	if len(battleRequest.Player2Pokemon) > 0 {
+		player2.Pokemon, rosterKeys[player2.ID], player2Problems = buildValidTeam(player2.Name, "player2_pokemon", battleRequest.Player2Pokemon)
+		problems = append(problems, player2Problems...)
+	}
+	if len(problems) > 0 {
+		writeTeamProblems(w, problems)
+		return
+	}
+	if computer != nil && len(player2.Pokemon) == 0 {
+		if player2.Pokemon, err = randomComputerTeam(len(player1.Pokemon), computer.rng); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 
-	// Start Battle
-	battle = &gameplay.Battle{
+	// Start Battle in a room of its own
+	room := rooms.add(&gameplay.Battle{
 		Player1: player1,
 		Player2: player2,
 		Turn:    1,
+		Mode:    mode,
+		Seed:    seed,
+	}, rosterKeys, false)
+	log.Printf("Started battle %s by %s", room.battle.ID, player1.Name)
+
+	// Respond with the initial battle state. A battle against the computer
+	// starts straight away.
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if computer != nil {
+		room.computer = computer
+		room.start()
+		room.recordActivity()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room.view(player1.ID))
+}
+
+// battleMode normalises a requested battle mode, defaulting to turns
+func battleMode(mode string) (string, error) {
+	mode = strings.ToLower(mode)
+	switch mode {
+	case "":
+		return gameplay.ModeTurns, nil
+	case gameplay.ModeTurns, gameplay.ModeSimultaneous:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid battle mode %s", mode)
+}
+
+// findRoom looks up the battle named in the request path
+func findRoom(w http.ResponseWriter, r *http.Request) (*battleRoom, bool) {
+	room, ok := rooms.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Battle not found", http.StatusNotFound)
 	}
+	return room, ok
+}
+
+// Handle a player taking the second seat of a battle
+func handleJoin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+	room, ok := findRoom(w, r)
+	if !ok {
+		return
+	}
+
+	// The joining player brings their team unless the battle was started
+	// with one for them
+	var joinRequest struct {
+		Pokemon []TeamMember `json:"pokemon"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&joinRequest); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	var team []gameplay.Pokemon
+	var keys []string
+	if len(joinRequest.Pokemon) > 0 {
+		var problems []teamProblem
+		if team, keys, problems = buildValidTeam(name, "pokemon", joinRequest.Pokemon); len(problems) > 0 {
+			writeTeamProblems(w, problems)
+			return
+		}
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	battle := room.battle
+	switch {
+	case room.closed:
+		http.Error(w, errBattleClosed.Error(), http.StatusNotFound)
+		return
+	case battle.Status != gameplay.BattlePending:
+		http.Error(w, "Battle already has two players", http.StatusConflict)
+		return
+	case name == battle.Player1.Name:
+		http.Error(w, "You can't battle yourself", http.StatusBadRequest)
+		return
+	case battle.Player2.Name != "" && name != battle.Player2.Name:
+		http.Error(w, "Battle is reserved for "+battle.Player2.Name, http.StatusForbidden)
+		return
+	}
+	if len(battle.Player2.Pokemon) == 0 {
+		if len(team) == 0 {
+			http.Error(w, "Choose a team to join with", http.StatusBadRequest)
+			return
+		}
+		battle.Player2.Pokemon = team
+		room.rosterKeys[battle.Player2.ID] = keys
+	}
+	battle.Player2.Name = name
+	room.start()
+	room.recordActivity()
+	room.publishUpdate()
+	log.Printf("%s joined battle %s against %s", name, battle.ID, battle.Player1.Name)
 
-	// Respond with the initial battle state
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(battle)
+	json.NewEncoder(w).Encode(room.view(battle.Player2.ID))
 }
 
-// Handle fetching the current battle state GET method
+// Handle fetching the current battle state GET method. Players see their
+// own side in full; anyone else sees what a spectator would.
 func handleBattleState(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if battle == nil {
-		http.Error(w, "No active battle", http.StatusNotFound)
+	room, ok := findRoom(w, r)
+	if !ok {
 		return
 	}
 
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	viewer := spectator
+	if name, err := sessionName(r); err == nil {
+		if playerID, err := room.playerID(name); err == nil {
+			viewer = playerID
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(battle)
+	json.NewEncoder(w).Encode(room.view(viewer))
+}
+
+// Handle fetching the replay of a battle that has ended GET method. Replays
+// of battles still in progress would give away choices not yet revealed.
+func handleReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Battles that have been cleaned up are still in the results
+	room, ok := rooms.get(r.PathValue("id"))
+	if !ok {
+		if replay, ok := results.replay(r.PathValue("id")); ok {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(replay)
+			return
+		}
+		http.Error(w, "Battle not found", http.StatusNotFound)
+		return
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if !room.battle.IsOver() {
+		http.Error(w, "The battle is still in progress", http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room.battle.Replay())
+}
+
+// Handle fetching everything that has happened in a battle, turn by turn GET
+// method. Battles that have been cleaned up are rebuilt from their replay.
+// Players see the exact damage their own Pokémon took, everyone else only
+// shares of HP.
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var battle *gameplay.Battle
+	if room, ok := rooms.get(r.PathValue("id")); ok {
+		room.mu.Lock()
+		defer room.mu.Unlock()
+		battle = room.battle
+	} else if replay, ok := results.replay(r.PathValue("id")); ok {
+		var err error
+		if battle, err = gameplay.ReplayBattle(replay); err != nil {
+			log.Printf("Failed to replay battle %s: %v", replay.BattleID, err)
+			http.Error(w, "Failed to rebuild the battle history", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		http.Error(w, "Battle not found", http.StatusNotFound)
+		return
+	}
+
+	history := gameplay.SpectatorHistory(battle)
+	if name, err := sessionName(r); err == nil {
+		for _, player := range []*gameplay.Player{&battle.Player1, &battle.Player2} {
+			if player.Name == name {
+				history = gameplay.PlayerHistory(battle, player.ID)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
 }
 
 // Handle actions for each turn
@@ -83,48 +311,142 @@ func handleAction(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Decode action request
-	var actionRequest struct {
-		PlayerID string `json:"player_id"`
-		Action   string `json:"action"`
-	}
+	var actionRequest gameplay.Action
 	if err := json.NewDecoder(r.Body).Decode(&actionRequest); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Check if the current turn matches the requesting player
-	isPlayer1Turn := battle.Turn%2 == 1
-	if (actionRequest.PlayerID == "player1" && !isPlayer1Turn) ||
-		(actionRequest.PlayerID == "player2" && isPlayer1Turn) {
-		http.Error(w, "Not your turn", http.StatusBadRequest)
+	name, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+	room, ok := findRoom(w, r)
+	if !ok {
+		return
+	}
+	battle := room.battle
+
+	// Every check and change below happens under the battle's lock, so two
+	// requests can never both pass the turn check and both act
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	switch {
+	case room.closed:
+		http.Error(w, errBattleClosed.Error(), http.StatusNotFound)
+		return
+	case battle.Status == gameplay.BattlePending:
+		http.Error(w, "Waiting for an opponent to join", http.StatusConflict)
+		return
+	case battle.IsOver():
+		http.Error(w, "The battle is over", http.StatusConflict)
+		return
+	}
+
+	// The acting player comes from the session token, never the request body
+	playerID, err := room.playerID(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
+	actionRequest.PlayerID = playerID
 
-	// Execute turn logic
-	switch strings.ToLower(actionRequest.Action) {
-	case "attack":
-		gameplay.ExecuteAttack(battle, actionRequest.PlayerID)
-	case "defend":
-		gameplay.ExecuteDefend(battle, actionRequest.PlayerID)
-	default:
-		http.Error(w, "Invalid action", http.StatusBadRequest)
+	// A player can give up at any time
+	if strings.ToLower(actionRequest.Action) == "forfeit" {
+		if err := room.forfeit(playerID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(room.view(playerID))
 		return
 	}
 
-	// Increment turn
-	battle.Turn++
+	// A player whose Pokémon fainted picks its replacement without using a turn
+	if gameplay.NeedsReplacement(battle, actionRequest.PlayerID) {
+		if strings.ToLower(actionRequest.Action) != "switch" {
+			http.Error(w, "Your Pokémon fainted, choose a replacement with the switch action", http.StatusBadRequest)
+			return
+		}
+		if err := room.replace(actionRequest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if battle.Mode == gameplay.ModeSimultaneous {
+		handleRoundAction(w, r, room, actionRequest)
+		return
+	} else if err := room.takeTurn(actionRequest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Respond with updated battle state
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(battle)
+	json.NewEncoder(w).Encode(room.view(playerID))
+}
+
+// Handle an action in simultaneous mode: store it, resolve the round once both
+// players have chosen, and answer both players with the same round result.
+// The caller holds the room's lock, which is released while waiting.
+func handleRoundAction(w http.ResponseWriter, r *http.Request, room *battleRoom, actionRequest gameplay.Action) {
+	done, err := room.submitRound(actionRequest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Wait for the other player's choice
+	room.mu.Unlock()
+	select {
+	case <-done:
+	case <-r.Context().Done():
+	}
+	room.mu.Lock()
+	if r.Context().Err() != nil {
+		return
+	}
+	if room.closed {
+		http.Error(w, errBattleClosed.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room.view(actionRequest.PlayerID))
 }
 
 // Main function to start the server
 func main() {
+	db = openStore()
+	tokenSecret = loadTokenSecret(db)
 
+	http.HandleFunc("/register", handleRegister)
+	http.HandleFunc("/login", handleLogin)
 	http.HandleFunc("/start_battle", handleBattleRequest)
-	http.HandleFunc("/battle", handleBattleState)
-	http.HandleFunc("/action", handleAction)
+	http.HandleFunc("/battle/{id}", handleBattleState)
+	http.HandleFunc("/battle/{id}/join", handleJoin)
+	http.HandleFunc("/battle/{id}/action", handleAction)
+	http.HandleFunc("/battle/{id}/events", handleBattleEvents)
+	http.HandleFunc("/battle/{id}/replay", handleReplay)
+	http.HandleFunc("/battle/{id}/history", handleHistory)
+	http.HandleFunc("/battle/{id}/spectate", handleSpectate)
+	http.HandleFunc("/battles", handleLiveBattles)
+	http.HandleFunc("/progress", handleProgress)
+	http.HandleFunc("/results", handleResults)
+	http.HandleFunc("/results/{id}", handleResult)
+	http.HandleFunc("/ladder", handleLadder)
+	http.HandleFunc("/ladder/{name}", handlePlayerStanding)
+	http.HandleFunc("/tournaments", handleTournaments)
+	http.HandleFunc("/tournaments/{id}", handleTournament)
+	http.HandleFunc("/tournaments/{id}/register", handleTournamentRegister)
+	http.HandleFunc("/tournaments/{id}/start", handleTournamentStart)
+	http.HandleFunc("/lobby/queue", handleQueue)
+	http.HandleFunc("/lobby/challenges", handleChallenges)
+	http.HandleFunc("/lobby/challenges/{id}/accept", handleAcceptChallenge)
+	http.HandleFunc("/lobby/challenges/{id}/decline", handleDeclineChallenge)
+
+	// Drop finished and abandoned battles in the background
+	go rooms.runCleanup()
 
 	// Start the server
 	log.Println("Starting battle server on :8080...")
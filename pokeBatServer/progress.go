@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"netcentric/gameplay"
+	"netcentric/storage"
+	"strings"
+)
+
+// progressStore keeps every named player's trained Pokémon between battles,
+// keyed by the name the player first brought them to battle under.
+type progressStore struct{}
+
+var progress = &progressStore{}
+
+// lookup finds a trained Pokémon by the name it was first used under or by
+// its current species, so "Charmander" still finds it after it evolves.
+func (store *progressStore) lookup(player, name string) (string, gameplay.TrainedPokemon, bool, error) {
+	name = strings.ToLower(name)
+	roster, err := store.roster(player)
+	if err != nil {
+		return name, gameplay.TrainedPokemon{}, false, err
+	}
+	if trained, ok := roster[name]; ok {
+		return name, trained, true, nil
+	}
+	for key, trained := range roster {
+		if trained.Species == name {
+			return key, trained, true, nil
+		}
+	}
+	return name, gameplay.TrainedPokemon{}, false, nil
+}
+
+// rosterEntry is a trained Pokémon to save under a player's roster key.
+type rosterEntry struct {
+	player  string
+	key     string
+	trained gameplay.TrainedPokemon
+	// Levels the Pokémon gained in the battle
+	levelsGained int
+}
+
+// save stores the trained Pokémon in one transaction.
+func (store *progressStore) save(entries []rosterEntry) error {
+	return db.Update(func(tx storage.Tx) error {
+		for _, entry := range entries {
+			if err := tx.Put(storage.Progress, storage.PlayerKey(entry.player, entry.key), entry.trained); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (store *progressStore) roster(player string) (map[string]gameplay.TrainedPokemon, error) {
+	roster := make(map[string]gameplay.TrainedPokemon)
+	prefix := storage.PlayerPrefix(player)
+	err := db.View(func(tx storage.Tx) error {
+		return tx.ForEach(storage.Progress, prefix, func(key string, value json.RawMessage) error {
+			var trained gameplay.TrainedPokemon
+			if err := json.Unmarshal(value, &trained); err != nil {
+				return fmt.Errorf("failed to decode trained Pokémon %s: %v", key, err)
+			}
+			roster[strings.TrimPrefix(key, prefix)] = trained
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return roster, nil
+}
+
+// buildTeamMember raises a team member for battle, using the player's trained
+// Pokémon if they have one. It returns the roster key to save progress under.
+func buildTeamMember(playerName string, member TeamMember) (gameplay.Pokemon, string, error) {
+	if playerName == "" {
+		pokemon, err := buildPokemon(member)
+		return pokemon, "", err
+	}
+
+	key, trained, ok, err := progress.lookup(playerName, member.Name)
+	if err != nil {
+		return gameplay.Pokemon{}, "", fmt.Errorf("failed to load %s's trained Pokémon: %v", playerName, err)
+	}
+	if !ok {
+		pokemon, err := buildPokemon(member)
+		return pokemon, key, err
+	}
+	pokemon, err := buildPokemon(TeamMember{Name: trained.Species, PokemonOptions: trained.Options()})
+	if err != nil {
+		return gameplay.Pokemon{}, "", err
+	}
+	pokemon.Experience = trained.Experience
+	return pokemon, key, nil
+}
+
+// trainedTeam copies out the progress a named player's Pokémon made in a
+// finished battle. The caller holds the room's lock.
+func trainedTeam(player *gameplay.Player, keys []string) []rosterEntry {
+	var entries []rosterEntry
+	for i, key := range keys {
+		if key == "" || i >= len(player.Pokemon) {
+			continue
+		}
+		entries = append(entries, rosterEntry{
+			player:       player.Name,
+			key:          key,
+			trained:      player.Pokemon[i].Trained(),
+			levelsGained: player.Pokemon[i].LevelsGained,
+		})
+	}
+	return entries
+}
+
+// saveProgress evolves the trained Pokémon whose level-ups in the battle
+// brought them to their evolution level and stores them.
+func saveProgress(entries []rosterEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	for i := range entries {
+		if _, err := gameplay.Evolve(&entries[i].trained, entries[i].levelsGained); err != nil {
+			log.Printf("Failed to check evolution for %s: %v", entries[i].trained.Species, err)
+		}
+	}
+	if err := progress.save(entries); err != nil {
+		log.Printf("Failed to save the progress of %d Pokémon: %v", len(entries), err)
+	}
+}
+
+// Handle fetching the requesting player's own trained Pokémon GET method
+func handleProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The player comes from the session token, never the query
+	player, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+	if requested := r.URL.Query().Get("player"); requested != "" && requested != player {
+		http.Error(w, "You can only see your own trained Pokémon", http.StatusForbidden)
+		return
+	}
+
+	roster, err := progress.roster(player)
+	if err != nil {
+		log.Printf("Failed to load %s's trained Pokémon: %v", player, err)
+		http.Error(w, "Failed to load trained Pokémon", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roster)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"netcentric/gameplay"
+	"testing"
+)
+
+func TestSaveProgressEvolvesOnlyAfterLevelingUp(t *testing.T) {
+	for _, test := range []struct {
+		levelsGained int
+		want         string
+	}{
+		{0, "Charmander"},
+		{1, "charmeleon"},
+	} {
+		entries := []rosterEntry{{
+			player:       "evolve-test",
+			key:          "Charmander",
+			trained:      gameplay.TrainedPokemon{Species: "Charmander", Level: 50},
+			levelsGained: test.levelsGained,
+		}}
+		saveProgress(entries)
+
+		roster, err := progress.roster("evolve-test")
+		if err != nil {
+			t.Fatalf("Failed to load the roster: %v", err)
+		}
+		if species := roster["Charmander"].Species; species != test.want {
+			t.Errorf("A level 50 Charmander that gained %d levels is a %s, want %s", test.levelsGained, species, test.want)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"netcentric/storage"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ratingsFile is where ratings were kept before the server had a store. It
+// is imported into the store once if it is still there.
+const ratingsFile = "ratings.json"
+
+const (
+	initialRating = 1500
+	// ratingK is the most points a single battle can move a rating
+	ratingK = 32
+	// Ladder pages hold defaultPageSize players unless the request asks for
+	// another size, up to maxPageSize
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// playerRating is one player's standing on the ladder.
+type playerRating struct {
+	Name      string    `json:"name"`
+	Rating    int       `json:"rating"`
+	Peak      int       `json:"peak"`
+	Wins      int       `json:"wins"`
+	Losses    int       `json:"losses"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ratedMatch is a finished battle between two players and the points it
+// moved between them.
+type ratedMatch struct {
+	BattleID     string    `json:"battle_id"`
+	Mode         string    `json:"mode"`
+	Status       string    `json:"status"`
+	Winner       string    `json:"winner"`
+	Loser        string    `json:"loser"`
+	WinnerRating int       `json:"winner_rating"` // before the battle
+	LoserRating  int       `json:"loser_rating"`  // before the battle
+	Change       int       `json:"change"`
+	EndedAt      time.Time `json:"ended_at"`
+}
+
+// ratingStore holds every player's rating, keyed by name, and the rated
+// matches behind them in the order they were played.
+type ratingStore struct{}
+
+var ratings = &ratingStore{}
+
+// importRatings loads the ratings and rated matches from a legacy ratings
+// file, leaving alone the players and battles the store already has.
+func importRatings(tx storage.Tx, data []byte) (int, error) {
+	var imported struct {
+		Players map[string]*playerRating `json:"players"`
+		Matches []ratedMatch             `json:"matches"`
+	}
+	if err := json.Unmarshal(data, &imported); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for name, rating := range imported.Players {
+		var existing playerRating
+		if err := tx.Get(storage.Ratings, name, &existing); err == nil {
+			continue
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			return 0, err
+		}
+		if err := tx.Put(storage.Ratings, name, rating); err != nil {
+			return 0, err
+		}
+		count++
+	}
+
+	rated := make(map[string]bool)
+	err := tx.ForEach(storage.RatedMatches, "", func(key string, value json.RawMessage) error {
+		var match ratedMatch
+		if err := json.Unmarshal(value, &match); err != nil {
+			return fmt.Errorf("failed to decode rated match %s: %v", key, err)
+		}
+		rated[match.BattleID] = true
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, match := range imported.Matches {
+		if rated[match.BattleID] {
+			continue
+		}
+		if err := putRatedMatch(tx, match); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func putRatedMatch(tx storage.Tx, match ratedMatch) error {
+	n, err := tx.NextSequence(storage.RatedMatches)
+	if err != nil {
+		return err
+	}
+	return tx.Put(storage.RatedMatches, storage.SequenceKey(n), match)
+}
+
+// expectedScore is the chance Elo gives a player rated a of beating one
+// rated b.
+func expectedScore(a, b int) float64 {
+	return 1 / (1 + math.Pow(10, float64(b-a)/400))
+}
+
+// getRating returns the player's rating, or a new one at the initial rating
+// for a player who hasn't had a rated battle.
+func getRating(tx storage.Tx, name string) (*playerRating, error) {
+	rating := &playerRating{Name: name, Rating: initialRating, Peak: initialRating}
+	if err := tx.Get(storage.Ratings, name, rating); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	return rating, nil
+}
+
+// rating returns a player's current rating, or the initial rating for a
+// player who hasn't had a rated battle.
+func (store *ratingStore) rating(name string) int {
+	var rating *playerRating
+	err := db.View(func(tx storage.Tx) (err error) {
+		rating, err = getRating(tx, name)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to load the rating of %s: %v", name, err)
+		return initialRating
+	}
+	return rating.Rating
+}
+
+// record updates both players' ratings after a battle between two players
+// ends. Battles against the computer, or without a winner, aren't rated.
+func (store *ratingStore) record(result battleResult) {
+	if result.WinnerName == "" || len(result.Players) != 2 {
+		return
+	}
+	loserName := result.Players[0].Name
+	if loserName == result.WinnerName {
+		loserName = result.Players[1].Name
+	}
+	if isComputerName(result.WinnerName) || isComputerName(loserName) {
+		return
+	}
+
+	err := db.Update(func(tx storage.Tx) error {
+		winner, err := getRating(tx, result.WinnerName)
+		if err != nil {
+			return err
+		}
+		loser, err := getRating(tx, loserName)
+		if err != nil {
+			return err
+		}
+
+		change := int(math.Round(ratingK * (1 - expectedScore(winner.Rating, loser.Rating))))
+		err = putRatedMatch(tx, ratedMatch{
+			BattleID:     result.BattleID,
+			Mode:         result.Mode,
+			Status:       result.Status,
+			Winner:       winner.Name,
+			Loser:        loser.Name,
+			WinnerRating: winner.Rating,
+			LoserRating:  loser.Rating,
+			Change:       change,
+			EndedAt:      result.EndedAt,
+		})
+		if err != nil {
+			return err
+		}
+		winner.Rating += change
+		winner.Peak = max(winner.Peak, winner.Rating)
+		winner.Wins++
+		loser.Rating -= change
+		loser.Losses++
+		winner.UpdatedAt, loser.UpdatedAt = result.EndedAt, result.EndedAt
+		if err := tx.Put(storage.Ratings, winner.Name, winner); err != nil {
+			return err
+		}
+		if err := tx.Put(storage.Ratings, loser.Name, loser); err != nil {
+			return err
+		}
+		log.Printf("Battle %s: %s gained %d points (%d), %s lost them (%d)",
+			result.BattleID, winner.Name, change, winner.Rating, loser.Name, loser.Rating)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to save ratings after battle %s: %v", result.BattleID, err)
+	}
+}
+
+// ladderEntry is a player's place on the ladder.
+type ladderEntry struct {
+	Rank int `json:"rank"`
+	playerRating
+}
+
+// rankPlayers ranks every rated player, highest rating first.
+func rankPlayers(tx storage.Tx) ([]ladderEntry, error) {
+	standings := []ladderEntry{}
+	err := tx.ForEach(storage.Ratings, "", func(key string, value json.RawMessage) error {
+		var entry ladderEntry
+		if err := json.Unmarshal(value, &entry.playerRating); err != nil {
+			return fmt.Errorf("failed to decode the rating of %s: %v", key, err)
+		}
+		standings = append(standings, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(standings, func(i, j int) bool {
+		if standings[i].Rating != standings[j].Rating {
+			return standings[i].Rating > standings[j].Rating
+		}
+		return standings[i].Name < standings[j].Name
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+	}
+	return standings, nil
+}
+
+// ladderPage is one page of the leaderboard.
+type ladderPage struct {
+	Page    int           `json:"page"`
+	PerPage int           `json:"per_page"`
+	Total   int           `json:"total"`
+	Players []ladderEntry `json:"players"`
+}
+
+func (store *ratingStore) ladder(page, perPage int) (ladderPage, error) {
+	var standings []ladderEntry
+	err := db.View(func(tx storage.Tx) (err error) {
+		standings, err = rankPlayers(tx)
+		return err
+	})
+	if err != nil {
+		return ladderPage{}, err
+	}
+	// Pages past the end are empty; checking before multiplying keeps a huge
+	// page number from overflowing
+	start := len(standings)
+	if page-1 < len(standings)/perPage+1 {
+		start = min((page-1)*perPage, len(standings))
+	}
+	end := min(start+perPage, len(standings))
+	return ladderPage{Page: page, PerPage: perPage, Total: len(standings), Players: standings[start:end]}, nil
+}
+
+// matchHistoryEntry is one rated match from a player's point of view.
+type matchHistoryEntry struct {
+	BattleID     string    `json:"battle_id"`
+	Mode         string    `json:"mode"`
+	Opponent     string    `json:"opponent"`
+	Won          bool      `json:"won"`
+	RatingBefore int       `json:"rating_before"`
+	RatingAfter  int       `json:"rating_after"`
+	Change       int       `json:"change"`
+	EndedAt      time.Time `json:"ended_at"`
+}
+
+// playerStanding is a player's place on the ladder and their rated matches.
+type playerStanding struct {
+	ladderEntry
+	Matches []matchHistoryEntry `json:"matches"`
+}
+
+// standing returns the player's place on the ladder and their most recent
+// rated matches, newest first, or storage.ErrNotFound for a player who
+// hasn't had a rated battle.
+func (store *ratingStore) standing(name string, limit int) (playerStanding, error) {
+	var standing playerStanding
+	var matches []ratedMatch
+	err := db.View(func(tx storage.Tx) error {
+		if err := tx.Get(storage.Ratings, name, &standing.playerRating); err != nil {
+			return err
+		}
+		standings, err := rankPlayers(tx)
+		if err != nil {
+			return err
+		}
+		for _, entry := range standings {
+			if entry.Name == name {
+				standing.ladderEntry = entry
+				break
+			}
+		}
+		return tx.ForEach(storage.RatedMatches, "", func(key string, value json.RawMessage) error {
+			var match ratedMatch
+			if err := json.Unmarshal(value, &match); err != nil {
+				return fmt.Errorf("failed to decode rated match %s: %v", key, err)
+			}
+			matches = append(matches, match)
+			return nil
+		})
+	})
+	if err != nil {
+		return playerStanding{}, err
+	}
+
+	standing.Matches = []matchHistoryEntry{}
+	for i := len(matches) - 1; i >= 0 && len(standing.Matches) < limit; i-- {
+		match := matches[i]
+		entry := matchHistoryEntry{BattleID: match.BattleID, Mode: match.Mode, EndedAt: match.EndedAt}
+		switch name {
+		case match.Winner:
+			entry.Opponent, entry.Won = match.Loser, true
+			entry.RatingBefore, entry.Change = match.WinnerRating, match.Change
+		case match.Loser:
+			entry.Opponent = match.Winner
+			entry.RatingBefore, entry.Change = match.LoserRating, -match.Change
+		default:
+			continue
+		}
+		entry.RatingAfter = entry.RatingBefore + entry.Change
+		standing.Matches = append(standing.Matches, entry)
+	}
+	return standing, nil
+}
+
+// queryInt reads a positive integer query parameter, or returns the default
+// when it is missing.
+func queryInt(r *http.Request, key string, defaultValue int) (int, bool) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return defaultValue, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+// Handle fetching a page of the leaderboard GET method
+func handleLadder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page, ok := queryInt(r, "page", 1)
+	if !ok {
+		http.Error(w, "Invalid page", http.StatusBadRequest)
+		return
+	}
+	perPage, ok := queryInt(r, "per_page", defaultPageSize)
+	if !ok || perPage > maxPageSize {
+		http.Error(w, "Invalid page size", http.StatusBadRequest)
+		return
+	}
+
+	ladder, err := ratings.ladder(page, perPage)
+	if err != nil {
+		log.Printf("Failed to load the ladder: %v", err)
+		http.Error(w, "Failed to load the ladder", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ladder)
+}
+
+// Handle fetching a player's rating and rated match history GET method
+func handlePlayerStanding(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, ok := queryInt(r, "limit", defaultPageSize)
+	if !ok {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	standing, err := ratings.standing(r.PathValue("name"), limit)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "Player has no rated battles", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load the standing of %s: %v", r.PathValue("name"), err)
+		http.Error(w, "Failed to load the player's standing", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(standing)
+}
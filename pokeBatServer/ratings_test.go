@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLadderPagePastTheEnd(t *testing.T) {
+	for _, page := range []string{"2", "9223372036854775807"} {
+		recorder := httptest.NewRecorder()
+		handleLadder(recorder, httptest.NewRequest(http.MethodGet, "/ladder?per_page=100&page="+page, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Page %s got %d %s", page, recorder.Code, recorder.Body)
+		}
+		var ladder ladderPage
+		if err := json.Unmarshal(recorder.Body.Bytes(), &ladder); err != nil {
+			t.Fatalf("Failed to decode page %s: %v", page, err)
+		}
+		if len(ladder.Players) != 0 {
+			t.Errorf("Page %s lists %d players, want none", page, len(ladder.Players))
+		}
+	}
+}
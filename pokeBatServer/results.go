@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"netcentric/gameplay"
+	"netcentric/storage"
+	"slices"
+	"sort"
+	"time"
+)
+
+// resultsFile is where battle results were kept before the server had a
+// store. It is imported into the store once if it is still there.
+const resultsFile = "battle_results.json"
+
+type resultMember struct {
+	Species string `json:"species"`
+	Level   int    `json:"level"`
+	HP      int    `json:"hp"`
+	MaxHP   int    `json:"max_hp"`
+}
+
+type resultPlayer struct {
+	ID   string         `json:"id"`
+	Name string         `json:"name"`
+	Team []resultMember `json:"team"`
+}
+
+// battleResult is the record kept of a battle after it ends.
+type battleResult struct {
+	BattleID   string         `json:"battle_id"`
+	Mode       string         `json:"mode"`
+	Status     string         `json:"status"`
+	Players    []resultPlayer `json:"players"`
+	Turns      int            `json:"turns"`
+	Winner     string         `json:"winner"`
+	WinnerName string         `json:"winner_name"`
+	StartedAt  time.Time      `json:"started_at"`
+	EndedAt    time.Time      `json:"ended_at"`
+	Duration   float64        `json:"duration_seconds"`
+
+	// Replay re-simulates the battle with gameplay.ReplayBattle. It is kept
+	// apart from the record and only sent with a single result.
+	Replay *gameplay.Replay `json:"replay,omitempty"`
+}
+
+// resultStore holds the battle results keyed by battle ID, their replays
+// apart from them, and an index of them in the order the battles ended.
+type resultStore struct{}
+
+var results = &resultStore{}
+
+// importResults loads the battle results from a legacy results file.
+func importResults(tx storage.Tx, data []byte) (int, error) {
+	var imported []battleResult
+	if err := json.Unmarshal(data, &imported); err != nil {
+		return 0, err
+	}
+	sort.SliceStable(imported, func(i, j int) bool { return imported[i].EndedAt.Before(imported[j].EndedAt) })
+	count := 0
+	for _, result := range imported {
+		added, err := putResult(tx, result)
+		if err != nil {
+			return 0, err
+		}
+		if added {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// putResult stores a battle's result, its replay, and its place in the
+// battle index. It reports false and stores nothing if the store already
+// has the battle.
+func putResult(tx storage.Tx, result battleResult) (bool, error) {
+	var existing battleResult
+	if err := tx.Get(storage.Battles, result.BattleID, &existing); err == nil {
+		return false, nil
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return false, err
+	}
+
+	replay := result.Replay
+	result.Replay = nil
+	if err := tx.Put(storage.Battles, result.BattleID, result); err != nil {
+		return false, err
+	}
+	if replay != nil {
+		if err := tx.Put(storage.Replays, result.BattleID, replay); err != nil {
+			return false, err
+		}
+	}
+	entry := storage.BattleIndexEntry{BattleID: result.BattleID}
+	for _, player := range result.Players {
+		entry.Players = append(entry.Players, player.Name)
+	}
+	return true, storage.AddToBattleIndex(tx, entry)
+}
+
+// newBattleResult records how a finished battle turned out.
+func newBattleResult(battle *gameplay.Battle, startedAt, endedAt time.Time) battleResult {
+	result := battleResult{
+		BattleID:  battle.ID,
+		Mode:      battle.Mode,
+		Status:    battle.Status,
+		Turns:     battle.Turn - 1,
+		Winner:    battle.Winner,
+		StartedAt: startedAt,
+		EndedAt:   endedAt,
+		Duration:  endedAt.Sub(startedAt).Seconds(),
+	}
+	replay := battle.Replay()
+	result.Replay = &replay
+	for _, player := range []*gameplay.Player{&battle.Player1, &battle.Player2} {
+		recorded := resultPlayer{ID: player.ID, Name: player.Name}
+		for _, pokemon := range player.Pokemon {
+			recorded.Team = append(recorded.Team, resultMember{
+				Species: pokemon.Name,
+				Level:   pokemon.Level,
+				HP:      pokemon.HP,
+				MaxHP:   pokemon.MaxHP,
+			})
+		}
+		if player.ID == battle.Winner {
+			result.WinnerName = player.Name
+		}
+		result.Players = append(result.Players, recorded)
+	}
+	return result
+}
+
+func (store *resultStore) add(result battleResult) {
+	err := db.Update(func(tx storage.Tx) error {
+		_, err := putResult(tx, result)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to save battle result %s: %v", result.BattleID, err)
+	}
+}
+
+// get returns a battle's result, without its replay.
+func (store *resultStore) get(battleID string) (battleResult, bool) {
+	var result battleResult
+	err := db.View(func(tx storage.Tx) error {
+		return tx.Get(storage.Battles, battleID, &result)
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Failed to load battle result %s: %v", battleID, err)
+		}
+		return battleResult{}, false
+	}
+	return result, true
+}
+
+func (store *resultStore) replay(battleID string) (gameplay.Replay, bool) {
+	var replay gameplay.Replay
+	err := db.View(func(tx storage.Tx) error {
+		return tx.Get(storage.Replays, battleID, &replay)
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Failed to load the replay of battle %s: %v", battleID, err)
+		}
+		return gameplay.Replay{}, false
+	}
+	return replay, true
+}
+
+// find returns the most recent results, newest first, optionally only the
+// ones the named player took part in. It walks the battle index back from
+// the newest battle, loading only the results it returns.
+func (store *resultStore) find(player string, limit int) ([]battleResult, error) {
+	found := []battleResult{}
+	err := db.View(func(tx storage.Tx) error {
+		return tx.ForEachReverse(storage.BattleIndex, "", func(key string, value json.RawMessage) error {
+			var entry storage.BattleIndexEntry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return fmt.Errorf("failed to decode battle index entry %s: %v", key, err)
+			}
+			if player != "" && !slices.Contains(entry.Players, player) {
+				return nil
+			}
+			var result battleResult
+			if err := tx.Get(storage.Battles, entry.BattleID, &result); err != nil {
+				return err
+			}
+			found = append(found, result)
+			if len(found) == limit {
+				return storage.Stop
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// Handle listing battle results GET method, optionally for one player
+func handleResults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, ok := queryInt(r, "limit", defaultPageSize)
+	if !ok {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	found, err := results.find(r.URL.Query().Get("player"), limit)
+	if err != nil {
+		log.Printf("Failed to list battle results: %v", err)
+		http.Error(w, "Failed to list battle results", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(found)
+}
+
+// Handle fetching the result of one battle GET method
+func handleResult(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, ok := results.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Result not found", http.StatusNotFound)
+		return
+	}
+	if replay, ok := results.replay(result.BattleID); ok {
+		result.Replay = &replay
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"netcentric/gameplay"
+	"sync"
+	"time"
+)
+
+const (
+	// How long a finished battle stays around for players to see the result
+	finishedBattleTTL = 10 * time.Minute
+	// How long a battle can go without any action before it is dropped
+	abandonedBattleTTL = 30 * time.Minute
+	cleanupInterval    = time.Minute
+)
+
+// errBattleClosed is returned to requests that were already waiting on a
+// room's lock when cleanup dropped it.
+var errBattleClosed = errors.New("battle no longer exists")
+
+// battleRoom holds one battle and the server-side state that goes with it.
+type battleRoom struct {
+	// mu serialises every read and change of the room's battle
+	mu     sync.Mutex
+	battle *gameplay.Battle
+
+	// Simultaneous rounds: the first player to submit waits on roundDone
+	// until the second player's action resolves the round
+	roundDone chan struct{}
+
+	// closed is set once cleanup has dropped the room, after which nothing
+	// may change the battle
+	closed bool
+
+	// Roster keys of each player's team members, so progress can be saved
+	// once the battle ends
+	rosterKeys map[string][]string
+	recorded   bool
+
+	// A tournament waits on the result of its battles, so one that is
+	// abandoned is settled rather than dropped
+	tournament bool
+
+	// Event streams watching the battle, each with the player ID it sees
+	// the battle as (spectator for spectators), and what has already been
+	// announced on them
+	subscribers  map[chan battleEvent]string
+	fainted      map[string]bool
+	gameOverSent bool
+
+	// The clock for the choice the battle is waiting on. timerGeneration
+	// tells a stale timer that fired after the clock was reset to do nothing,
+	// and timingOut is set while the server acts for players who ran out of
+	// time, which doesn't keep an abandoned battle alive.
+	turnTimer       *time.Timer
+	turnDeadline    time.Time
+	timerGeneration int
+	timingOut       bool
+
+	// The seat played by the server, if the battle is against the computer
+	computer *computerPlayer
+
+	startedAt    time.Time
+	lastActivity time.Time
+	finishedAt   time.Time
+}
+
+// roomRegistry is the concurrency-safe set of battles currently hosted.
+type roomRegistry struct {
+	mu    sync.RWMutex
+	rooms map[string]*battleRoom
+}
+
+var rooms = &roomRegistry{rooms: make(map[string]*battleRoom)}
+
+// newID returns a random hex identifier for battles and challenges.
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Failed to generate ID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// add registers a battle under a new unique ID. It starts straight away when
+// both players already have their seats, and is pending until the second
+// player joins otherwise.
+func (registry *roomRegistry) add(battle *gameplay.Battle, rosterKeys map[string][]string, seated bool) *battleRoom {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for {
+		battle.ID = newID()
+		if _, taken := registry.rooms[battle.ID]; !taken {
+			break
+		}
+	}
+	room := &battleRoom{
+		battle:       battle,
+		roundDone:    make(chan struct{}),
+		rosterKeys:   rosterKeys,
+		lastActivity: time.Now(),
+	}
+	battle.Status = gameplay.BattlePending
+	if seated {
+		room.start()
+	}
+	registry.rooms[battle.ID] = room
+	return room
+}
+
+func (registry *roomRegistry) get(id string) (*battleRoom, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	room, ok := registry.rooms[id]
+	return room, ok
+}
+
+// cleanup drops battles that finished or were abandoned long enough ago.
+func (registry *roomRegistry) cleanup(now time.Time) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for id, room := range registry.rooms {
+		room.mu.Lock()
+		finished := !room.finishedAt.IsZero() && now.Sub(room.finishedAt) > finishedBattleTTL
+		abandoned := now.Sub(room.lastActivity) > abandonedBattleTTL
+		if abandoned && room.tournament {
+			room.settleAbandoned()
+		}
+		if finished || abandoned {
+			room.stopTurnTimer()
+			room.closed = true
+			close(room.roundDone)
+			for events := range room.subscribers {
+				room.unsubscribe(events)
+			}
+		}
+		room.mu.Unlock()
+
+		if finished || abandoned {
+			delete(registry.rooms, id)
+			log.Printf("Removed battle %s (finished: %t, abandoned: %t)", id, finished, abandoned)
+		}
+	}
+}
+
+// settleAbandoned forfeits an abandoned battle for the player it was waiting
+// on, so that its result is recorded. When both players stopped choosing,
+// the first of them forfeits. The caller holds the room's lock.
+func (room *battleRoom) settleAbandoned() {
+	if room.closed || room.recorded || room.battle.Status != gameplay.BattleActive {
+		return
+	}
+	idle := room.waitingOn()
+	if len(idle) == 0 {
+		return
+	}
+	log.Printf("Battle %s: %s abandoned the battle", room.battle.ID, idle[0])
+	if err := room.forfeit(idle[0]); err != nil {
+		log.Printf("Battle %s: failed to forfeit for %s: %v", room.battle.ID, idle[0], err)
+	}
+}
+
+// runCleanup periodically removes finished and abandoned battles.
+func (registry *roomRegistry) runCleanup() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		registry.cleanup(now)
+	}
+}
+
+// start begins the battle once both players are seated. The caller holds
+// the room's lock.
+func (room *battleRoom) start() {
+	gameplay.StartBattle(room.battle)
+	room.startedAt = time.Now()
+	room.resetTurnTimer()
+	room.playComputer()
+}
+
+// state returns the battle with the time left on the clock filled in. The
+// caller holds the room's lock.
+func (room *battleRoom) state() *gameplay.Battle {
+	room.battle.TurnTimeLeft = 0
+	if !room.turnDeadline.IsZero() {
+		room.battle.TurnTimeLeft = int(time.Until(room.turnDeadline).Seconds() + 0.5)
+	}
+	return room.battle
+}
+
+// takeTurn carries out the action of the player whose turn it is and passes
+// the turn. The caller holds the room's lock.
+func (room *battleRoom) takeTurn(action gameplay.Action) error {
+	if room.closed {
+		return errBattleClosed
+	}
+	battle := room.battle
+
+	// Check if the current turn matches the requesting player
+	isPlayer1Turn := battle.Turn%2 == 1
+	if (action.PlayerID == battle.Player1.ID) != isPlayer1Turn {
+		return fmt.Errorf("not your turn")
+	}
+
+	// Execute turn logic, apply end-of-turn effects and pass the turn
+	if err := gameplay.PlayTurn(battle, action); err != nil {
+		return err
+	}
+	room.recordActivity()
+	room.resetTurnTimer()
+	room.publishUpdate()
+	room.playComputer()
+	return nil
+}
+
+// replace sends in the replacement a player picked after a faint. The
+// caller holds the room's lock.
+func (room *battleRoom) replace(action gameplay.Action) error {
+	if room.closed {
+		return errBattleClosed
+	}
+	if err := gameplay.ReplaceFainted(room.battle, action.PlayerID, action.Switch); err != nil {
+		return err
+	}
+	room.recordActivity()
+	room.resetTurnTimer()
+	room.publishUpdate()
+	room.playComputer()
+	return nil
+}
+
+// submitRound stores a player's choice for a simultaneous round, resolving
+// the round once both have chosen. It returns the channel closed when the
+// round is resolved. The caller holds the room's lock.
+func (room *battleRoom) submitRound(action gameplay.Action) (chan struct{}, error) {
+	if room.closed {
+		return nil, errBattleClosed
+	}
+	ready, err := gameplay.SubmitAction(room.battle, action)
+	if err != nil {
+		return nil, err
+	}
+	room.recordActivity()
+	done := room.roundDone
+	if ready {
+		if err := gameplay.ResolveRound(room.battle); err != nil {
+			log.Printf("Failed to resolve round: %v", err)
+		}
+		room.endRound()
+	}
+	return done, nil
+}
+
+// endRound releases the players waiting on the current round. Cleanup has
+// already released them from a closed room. The caller holds the room's lock.
+func (room *battleRoom) endRound() {
+	if room.closed {
+		return
+	}
+	room.recordActivity()
+	room.resetTurnTimer()
+	room.publishUpdate()
+	close(room.roundDone)
+	room.roundDone = make(chan struct{})
+	room.playComputer()
+}
+
+// forfeit ends the battle with the player giving up, releasing an opponent
+// who is waiting on the round. The caller holds the room's lock.
+func (room *battleRoom) forfeit(playerID string) error {
+	if room.closed {
+		return errBattleClosed
+	}
+	if err := gameplay.Forfeit(room.battle, playerID); err != nil {
+		return err
+	}
+	room.endRound()
+	return nil
+}
+
+// recordActivity notes that the battle moved on and, the first time it is
+// found to be over, saves the players' progress and the battle's result. The
+// caller holds the room's lock.
+func (room *battleRoom) recordActivity() {
+	now := time.Now()
+	if !room.timingOut {
+		room.lastActivity = now
+	}
+	if room.recorded || !room.battle.IsOver() {
+		return
+	}
+	room.recorded = true
+	room.finishedAt = now
+	trained := append(trainedTeam(&room.battle.Player1, room.rosterKeys[room.battle.Player1.ID]),
+		trainedTeam(&room.battle.Player2, room.rosterKeys[room.battle.Player2.ID])...)
+	// Saving rewrites the store and tournaments may start the next round's
+	// battles, neither of which belongs under this room's lock
+	go recordResult(trained, newBattleResult(room.battle, room.startedAt, room.finishedAt))
+}
+
+// recordResult saves what a finished battle changed: its players' progress,
+// its result and their ratings, then lets a tournament it belonged to move
+// on.
+func recordResult(trained []rosterEntry, result battleResult) {
+	saveProgress(trained)
+	results.add(result)
+	ratings.record(result)
+	tournaments.battleFinished(result)
+}
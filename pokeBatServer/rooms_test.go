@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"netcentric/gameplay"
+	"netcentric/storage"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Concurrent requests each player sends in a burst
+const burstSize = 10
+
+// battlesStarted numbers the test battles so each has players of its own
+var battlesStarted atomic.Int64
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "pokebat-test")
+	if err != nil {
+		log.Fatalf("Failed to create test directory: %v", err)
+	}
+	if db, err = storage.OpenFile(filepath.Join(dir, "pokemon.db")); err != nil {
+		log.Fatalf("Failed to open test store: %v", err)
+	}
+	tokenSecret = []byte("test secret")
+	// Hashing passwords slowly only slows the tests down
+	passwordIterations = 1
+	// Players act when the test says so, never the clock
+	timerConfig.Limit = 0
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", handleRegister)
+	mux.HandleFunc("/login", handleLogin)
+	mux.HandleFunc("/start_battle", handleBattleRequest)
+	mux.HandleFunc("/battle/{id}/join", handleJoin)
+	mux.HandleFunc("/battle/{id}/action", handleAction)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// post sends a JSON body with the player's token and returns the status and
+// response body.
+func post(t *testing.T, url, token string, body interface{}) (int, string) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("Failed to encode request: %v", err)
+	}
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Errorf("Request to %s failed: %v", url, err)
+		return 0, ""
+	}
+	defer response.Body.Close()
+	text, _ := io.ReadAll(response.Body)
+	return response.StatusCode, string(text)
+}
+
+func register(t *testing.T, server *httptest.Server, name string) string {
+	status, body := post(t, server.URL+"/register", "", map[string]string{"name": name, "password": "password"})
+	if status != http.StatusOK {
+		t.Fatalf("Registering %s: %d %s", name, status, body)
+	}
+	var registered struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal([]byte(body), &registered); err != nil {
+		t.Fatalf("Failed to decode registration: %v", err)
+	}
+	return registered.Token
+}
+
+// startBattle seats two new players in a battle of the given mode and returns
+// its room and both players' tokens.
+func startBattle(t *testing.T, server *httptest.Server, mode string) (*battleRoom, string, string) {
+	n := battlesStarted.Add(1)
+	name1, name2 := fmt.Sprintf("%s-%d-one", mode, n), fmt.Sprintf("%s-%d-two", mode, n)
+	token1, token2 := register(t, server, name1), register(t, server, name2)
+
+	team := []map[string]string{{"name": "Squirtle"}, {"name": "Pikachu"}}
+	status, body := post(t, server.URL+"/start_battle", token1, map[string]interface{}{
+		"player1_pokemon": team,
+		"player2_name":    name2,
+		"mode":            mode,
+		"seed":            1,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("Starting battle: %d %s", status, body)
+	}
+	var started struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(body), &started); err != nil {
+		t.Fatalf("Failed to decode battle: %v", err)
+	}
+	if status, body := post(t, server.URL+"/battle/"+started.ID+"/join", token2, map[string]interface{}{"pokemon": team}); status != http.StatusOK {
+		t.Fatalf("Joining battle: %d %s", status, body)
+	}
+
+	room, ok := rooms.get(started.ID)
+	if !ok {
+		t.Fatalf("Battle %s has no room", started.ID)
+	}
+	return room, token1, token2
+}
+
+type burstResult struct {
+	accepted map[string]int // per token
+	refused  []string       // response bodies
+}
+
+// burst has the players send burstSize defend actions each at once and
+// waits for every response. Defending never knocks a Pokémon out, so the battle
+// goes on for as many bursts as the test sends.
+func burst(t *testing.T, url string, tokens ...string) burstResult {
+	result := burstResult{accepted: make(map[string]int)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, token := range tokens {
+		for i := 0; i < burstSize; i++ {
+			wg.Add(1)
+			go func(token string) {
+				defer wg.Done()
+				status, body := post(t, url, token, gameplay.Action{Action: "defend"})
+				mu.Lock()
+				defer mu.Unlock()
+				if status == http.StatusOK {
+					result.accepted[token]++
+				} else {
+					result.refused = append(result.refused, body)
+				}
+			}(token)
+		}
+	}
+	wg.Wait()
+	return result
+}
+
+// checkHistory checks that the battle has played exactly the given number of
+// turns, each logged once and in order.
+func checkHistory(t *testing.T, room *battleRoom, played int) {
+	t.Helper()
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.battle.Turn != played+1 {
+		t.Errorf("Battle is on turn %d after %d accepted actions, want %d", room.battle.Turn, played, played+1)
+	}
+	if len(room.battle.History) != played {
+		t.Fatalf("History has %d turns after %d accepted actions", len(room.battle.History), played)
+	}
+	for i, turn := range room.battle.History {
+		if turn.Turn != i+1 {
+			t.Fatalf("History entry %d is for turn %d", i, turn.Turn)
+		}
+	}
+}
+
+func TestConcurrentTurnActions(t *testing.T) {
+	server := newTestServer(t)
+	room, token1, token2 := startBattle(t, server, gameplay.ModeTurns)
+	url := server.URL + "/battle/" + room.battle.ID + "/action"
+
+	// The second player can't act on the first player's turn
+	status, body := post(t, url, token2, gameplay.Action{Action: "defend"})
+	if status != http.StatusBadRequest || !strings.Contains(body, "not your turn") {
+		t.Fatalf("Out-of-turn action got %d %q, want 400 not your turn", status, body)
+	}
+	checkHistory(t, room, 0)
+
+	played := 0
+	for i := 0; i < 20; i++ {
+		result := burst(t, url, token1, token2)
+		accepted := result.accepted[token1] + result.accepted[token2]
+		if accepted == 0 {
+			t.Fatalf("Burst %d: no action was accepted", i)
+		}
+		// Turns alternate, so neither player can have acted twice more than
+		// the other within a burst
+		if diff := result.accepted[token1] - result.accepted[token2]; diff < -1 || diff > 1 {
+			t.Errorf("Burst %d: players acted %d and %d times", i, result.accepted[token1], result.accepted[token2])
+		}
+		for _, refused := range result.refused {
+			if !strings.Contains(refused, "not your turn") {
+				t.Errorf("Burst %d: action refused with %q, want not your turn", i, refused)
+			}
+		}
+		played += accepted
+		checkHistory(t, room, played)
+	}
+}
+
+func TestConcurrentRoundActions(t *testing.T) {
+	server := newTestServer(t)
+	room, token1, token2 := startBattle(t, server, gameplay.ModeSimultaneous)
+	url := server.URL + "/battle/" + room.battle.ID + "/action"
+
+	type response struct {
+		status int
+		body   string
+	}
+	for i := 0; i < 20; i++ {
+		// The first player's choices race each other. One is kept and waits
+		// for the round, the rest are refused.
+		responses := make(chan response, burstSize)
+		for j := 0; j < burstSize; j++ {
+			go func() {
+				status, body := post(t, url, token1, gameplay.Action{Action: "defend"})
+				responses <- response{status, body}
+			}()
+		}
+		for j := 0; j < burstSize-1; j++ {
+			refused := <-responses
+			if refused.status != http.StatusBadRequest || !strings.Contains(refused.body, "already chose an action this round") {
+				t.Fatalf("Round %d: repeated choice got %d %q", i, refused.status, refused.body)
+			}
+		}
+		room.mu.Lock()
+		_, chosen := room.battle.PendingActions["player1"]
+		room.mu.Unlock()
+		if !chosen {
+			t.Fatalf("Round %d: none of the first player's choices was kept", i)
+		}
+
+		// The second player's choice resolves the round for both
+		if status, body := post(t, url, token2, gameplay.Action{Action: "defend"}); status != http.StatusOK {
+			t.Fatalf("Round %d: second player's choice got %d %q", i, status, body)
+		}
+		if kept := <-responses; kept.status != http.StatusOK {
+			t.Fatalf("Round %d: first player's choice got %d %q", i, kept.status, kept.body)
+		}
+		checkHistory(t, room, i+1)
+	}
+}
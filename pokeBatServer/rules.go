@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"netcentric/gameplay"
+	"os"
+	"strings"
+)
+
+// teamRulesFile overrides the default team rules when it exists.
+const teamRulesFile = "team_rules.json"
+
+// TeamRules are the limits every team brought to a battle must respect.
+type TeamRules struct {
+	MinTeamSize     int      `json:"min_team_size"`
+	MaxTeamSize     int      `json:"max_team_size"`
+	MaxLevel        int      `json:"max_level"`
+	AllowDuplicates bool     `json:"allow_duplicates"`
+	Banned          []string `json:"banned"`
+}
+
+// defaultTeamRules allow one to six different Pokémon up to level 100, with
+// no legendary or mythical Pokémon.
+var defaultTeamRules = TeamRules{
+	MinTeamSize: 1,
+	MaxTeamSize: 6,
+	MaxLevel:    gameplay.MaxLevel,
+	Banned: []string{
+		"articuno", "zapdos", "moltres", "mewtwo", "mew",
+		"raikou", "entei", "suicune", "lugia", "ho-oh", "celebi",
+		"regirock", "regice", "registeel", "latias", "latios",
+		"kyogre", "groudon", "rayquaza", "jirachi", "deoxys-normal",
+		"uxie", "mesprit", "azelf", "dialga", "palkia", "heatran",
+		"regigigas", "giratina-altered", "cresselia", "phione", "manaphy",
+		"darkrai", "shaymin-land", "arceus",
+		"victini", "cobalion", "terrakion", "virizion", "tornadus-incarnate",
+		"thundurus-incarnate", "reshiram", "zekrom", "landorus-incarnate",
+		"kyurem", "keldeo-ordinary", "meloetta-aria", "genesect",
+	},
+}
+
+var teamRules = loadTeamRules()
+
+// loadTeamRules reads the team rules file, falling back to the defaults for
+// a missing file or any limit it leaves out.
+func loadTeamRules() TeamRules {
+	rules := defaultTeamRules
+	data, err := os.ReadFile(teamRulesFile)
+	if os.IsNotExist(err) {
+		return rules
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &rules)
+	}
+	if err != nil {
+		log.Fatalf("Failed to load team rules from %s: %v", teamRulesFile, err)
+	}
+	log.Printf("Loaded team rules from %s: %d to %d Pokémon up to level %d, %d banned",
+		teamRulesFile, rules.MinTeamSize, rules.MaxTeamSize, rules.MaxLevel, len(rules.Banned))
+	return rules
+}
+
+// teamProblem is one way a team breaks the rules.
+type teamProblem struct {
+	Team    string `json:"team"`
+	Member  string `json:"member,omitempty"`
+	Problem string `json:"problem"`
+}
+
+// checkSize reports a team with too few or too many Pokémon.
+func (rules TeamRules) checkSize(teamField string, members []TeamMember) []teamProblem {
+	if len(members) >= rules.MinTeamSize && len(members) <= rules.MaxTeamSize {
+		return nil
+	}
+	return []teamProblem{{
+		Team:    teamField,
+		Problem: fmt.Sprintf("team must have %d to %d Pokémon, got %d", rules.MinTeamSize, rules.MaxTeamSize, len(members)),
+	}}
+}
+
+// checkMember lists every rule a team member breaks before it is raised.
+// seen holds the species already on the team.
+func (rules TeamRules) checkMember(member TeamMember, seen map[string]bool) []string {
+	var problems []string
+	species := strings.ToLower(member.Name)
+	for _, name := range rules.Banned {
+		if strings.ToLower(name) == species {
+			problems = append(problems, "banned from battles")
+			break
+		}
+	}
+	if seen[species] && !rules.AllowDuplicates {
+		problems = append(problems, "already on the team")
+	}
+	seen[species] = true
+	if member.Level > rules.MaxLevel {
+		problems = append(problems, fmt.Sprintf("level %d is above the level cap of %d", member.Level, rules.MaxLevel))
+	}
+	return problems
+}
+
+// buildValidTeam checks a player's team against the rules and raises it,
+// listing every problem found instead of stopping at the first. Trained
+// Pokémon are checked against the level cap at the level they have reached.
+func buildValidTeam(playerName, teamField string, members []TeamMember) ([]gameplay.Pokemon, []string, []teamProblem) {
+	problems := teamRules.checkSize(teamField, members)
+
+	team := make([]gameplay.Pokemon, 0, len(members))
+	keys := make([]string, 0, len(members))
+	seen := make(map[string]bool, len(members))
+	for _, member := range members {
+		memberProblems := teamRules.checkMember(member, seen)
+		if len(memberProblems) == 0 {
+			pokemon, key, err := buildTeamMember(playerName, member)
+			switch {
+			case err != nil:
+				memberProblems = append(memberProblems, err.Error())
+			case pokemon.Level > teamRules.MaxLevel:
+				memberProblems = append(memberProblems, fmt.Sprintf("trained to level %d, above the level cap of %d", pokemon.Level, teamRules.MaxLevel))
+			}
+			team = append(team, pokemon)
+			keys = append(keys, key)
+		}
+		for _, problem := range memberProblems {
+			problems = append(problems, teamProblem{Team: teamField, Member: member.Name, Problem: problem})
+		}
+	}
+	if len(problems) > 0 {
+		return nil, nil, problems
+	}
+	return team, keys, nil
+}
+
+// writeTeamProblems answers a request whose teams broke the rules.
+func writeTeamProblems(w http.ResponseWriter, problems []teamProblem) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(struct {
+		Error    string        `json:"error"`
+		Problems []teamProblem `json:"problems"`
+	}{Error: "invalid team", Problems: problems})
+}
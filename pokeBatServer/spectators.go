@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"netcentric/gameplay"
+	"sort"
+	"time"
+)
+
+// liveBattle is how a battle in progress appears in the list spectators pick
+// from.
+type liveBattle struct {
+	ID         string    `json:"id"`
+	Mode       string    `json:"mode"`
+	Turn       int       `json:"turn"`
+	Player1    string    `json:"player1"`
+	Player2    string    `json:"player2"`
+	Spectators int       `json:"spectators"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+// live lists the battles being played right now, longest running first.
+func (registry *roomRegistry) live() []liveBattle {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	battles := []liveBattle{}
+	for _, room := range registry.rooms {
+		room.mu.Lock()
+		if room.battle.Status == gameplay.BattleActive {
+			battles = append(battles, liveBattle{
+				ID:         room.battle.ID,
+				Mode:       room.battle.Mode,
+				Turn:       room.battle.Turn,
+				Player1:    room.battle.Player1.Name,
+				Player2:    room.battle.Player2.Name,
+				Spectators: room.spectators(),
+				StartedAt:  room.startedAt,
+			})
+		}
+		room.mu.Unlock()
+	}
+	sort.Slice(battles, func(i, j int) bool { return battles[i].StartedAt.Before(battles[j].StartedAt) })
+	return battles
+}
+
+// Handle listing the battles in progress GET method
+func handleLiveBattles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rooms.live())
+}
+
+// Handle a spectator's Server-Sent Events stream of a battle GET method.
+// Spectators see only what has been revealed and can't act.
+func handleSpectate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+	room, ok := findRoom(w, r)
+	if !ok {
+		return
+	}
+	log.Printf("%s is watching battle %s", name, room.battle.ID)
+	streamBattle(w, r, room, spectator)
+}
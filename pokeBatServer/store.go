@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"netcentric/storage"
+	"os"
+)
+
+// db keeps the registered players, their trained Pokémon, battle results and
+// ratings across restarts, in the store shared with the Pokédex server and
+// pokeCatch. It is opened when the server starts.
+var db storage.Store
+
+func openStore() storage.Store {
+	store, err := storage.Open()
+	if err != nil {
+		log.Fatalf("Failed to open store: %v", err)
+	}
+	log.Printf("Opened store at schema version %d", store.Version())
+
+	importLegacyFile(store, resultsFile, importResults)
+	importLegacyFile(store, ratingsFile, importRatings)
+	return store
+}
+
+// importLegacyFile copies the records the server kept in a JSON file of its
+// own, before it had a store, into the store. The file is renamed afterwards
+// so they are only imported once.
+func importLegacyFile(store storage.Store, path string, load func(tx storage.Tx, data []byte) (int, error)) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", path, err)
+	}
+
+	var imported int
+	err = store.Update(func(tx storage.Tx) error {
+		var err error
+		imported, err = load(tx, data)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("Failed to import %s: %v", path, err)
+	}
+	if err := os.Rename(path, path+".imported"); err != nil {
+		log.Fatalf("Failed to rename %s after importing it: %v", path, err)
+	}
+	log.Printf("Imported %d records from %s", imported, path)
+}
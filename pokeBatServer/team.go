@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"netcentric/gameplay"
+	"netcentric/utils"
+	"strings"
+)
+
+// TeamMember is one Pokémon in a team sent to /start_battle. It can be given
+// as just a species name ("Pikachu") or as an object with the level, IVs, EVs,
+// nature and moves to battle with.
+type TeamMember struct {
+	Name string `json:"name"`
+	gameplay.PokemonOptions
+}
+
+func (member *TeamMember) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*member = TeamMember{Name: name}
+		return nil
+	}
+
+	type teamMember TeamMember
+	var full teamMember
+	if err := json.Unmarshal(data, &full); err != nil {
+		return err
+	}
+	*member = TeamMember(full)
+	return nil
+}
+
+// buildPokemon looks up the member's species and raises it for battle
+func buildPokemon(member TeamMember) (gameplay.Pokemon, error) {
+	number, exists := utils.PokeMap[strings.Title(strings.ToLower(member.Name))]
+	if !exists {
+		return gameplay.Pokemon{}, fmt.Errorf("pokémon %s not found", member.Name)
+	}
+	pokemon, err := gameplay.NewBattlePokemon(number, member.PokemonOptions)
+	if err != nil {
+		return gameplay.Pokemon{}, fmt.Errorf("%s: %v", member.Name, err)
+	}
+	return pokemon, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"log"
+	"netcentric/gameplay"
+	"os"
+	"strconv"
+	"time"
+)
+
+// What happens to a player who runs out of time to choose.
+const (
+	timeoutDefaultAction = "default"
+	timeoutForfeit       = "forfeit"
+)
+
+// turnTimerConfig is how long players get for each choice and what happens
+// when the time runs out. POKEBAT_TURN_SECONDS sets the limit, 0 turning the
+// timer off, and POKEBAT_TURN_TIMEOUT picks "default" or "forfeit".
+type turnTimerConfig struct {
+	Limit     time.Duration
+	OnTimeout string
+}
+
+var timerConfig = loadTurnTimerConfig()
+
+func loadTurnTimerConfig() turnTimerConfig {
+	config := turnTimerConfig{Limit: 60 * time.Second, OnTimeout: timeoutDefaultAction}
+	if value := os.Getenv("POKEBAT_TURN_SECONDS"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			log.Fatalf("Invalid POKEBAT_TURN_SECONDS %q", value)
+		}
+		config.Limit = time.Duration(seconds) * time.Second
+	}
+	switch value := os.Getenv("POKEBAT_TURN_TIMEOUT"); value {
+	case "":
+	case timeoutDefaultAction, timeoutForfeit:
+		config.OnTimeout = value
+	default:
+		log.Fatalf("Invalid POKEBAT_TURN_TIMEOUT %q, use %q or %q", value, timeoutDefaultAction, timeoutForfeit)
+	}
+	return config
+}
+
+// stopTurnTimer stops the clock. The caller holds the room's lock.
+func (room *battleRoom) stopTurnTimer() {
+	room.timerGeneration++
+	if room.turnTimer != nil {
+		room.turnTimer.Stop()
+		room.turnTimer = nil
+	}
+	room.turnDeadline = time.Time{}
+}
+
+// resetTurnTimer starts the clock for the next choice, or stops it once the
+// battle is over. The caller holds the room's lock.
+func (room *battleRoom) resetTurnTimer() {
+	room.stopTurnTimer()
+	if timerConfig.Limit <= 0 || room.battle.Status != gameplay.BattleActive {
+		return
+	}
+	generation := room.timerGeneration
+	room.turnDeadline = time.Now().Add(timerConfig.Limit)
+	room.turnTimer = time.AfterFunc(timerConfig.Limit, func() {
+		room.turnTimedOut(generation)
+	})
+}
+
+// waitingOn returns the players the battle is waiting for a choice from. The
+// caller holds the room's lock.
+func (room *battleRoom) waitingOn() []string {
+	battle := room.battle
+	var waiting []string
+	for _, player := range []*gameplay.Player{&battle.Player1, &battle.Player2} {
+		if player.MustSwitch {
+			waiting = append(waiting, player.ID)
+		}
+	}
+	if len(waiting) > 0 {
+		return waiting
+	}
+
+	if battle.Mode == gameplay.ModeSimultaneous {
+		for _, id := range []string{battle.Player1.ID, battle.Player2.ID} {
+			if _, chosen := battle.PendingActions[id]; !chosen {
+				waiting = append(waiting, id)
+			}
+		}
+		return waiting
+	}
+	if battle.Turn%2 == 1 {
+		return []string{battle.Player1.ID}
+	}
+	return []string{battle.Player2.ID}
+}
+
+// turnTimedOut acts for every player who didn't choose in time, either
+// forfeiting the battle for them or taking their default action. Those
+// choices don't count as activity, so a battle nobody plays any more is
+// still dropped once abandoned.
+func (room *battleRoom) turnTimedOut(generation int) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if room.closed || generation != room.timerGeneration || room.battle.Status != gameplay.BattleActive {
+		return
+	}
+	room.timingOut = true
+	defer func() { room.timingOut = false }()
+
+	for _, playerID := range room.waitingOn() {
+		log.Printf("Battle %s: %s ran out of time", room.battle.ID, playerID)
+		if timerConfig.OnTimeout == timeoutForfeit {
+			if err := room.forfeit(playerID); err != nil {
+				log.Printf("Battle %s: failed to forfeit for %s: %v", room.battle.ID, playerID, err)
+			}
+			return
+		}
+
+		if err := room.act(gameplay.DefaultAction(room.battle, playerID)); err != nil {
+			log.Printf("Battle %s: failed to take default action for %s: %v", room.battle.ID, playerID, err)
+		}
+		// Having no PP left, the player may have forfeited
+		if room.battle.Status != gameplay.BattleActive {
+			return
+		}
+	}
+}
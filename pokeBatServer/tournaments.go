@@ -0,0 +1,609 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/bits"
+	"net/http"
+	"netcentric/gameplay"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tournament formats.
+const (
+	formatSingleElimination = "single_elimination"
+	formatDoubleElimination = "double_elimination"
+	formatSwiss             = "swiss"
+)
+
+// Tournament statuses.
+const (
+	tournamentRegistering = "registering" // taking entrants
+	tournamentRunning     = "running"     // rounds are being played
+	tournamentFinished    = "finished"    // a champion has been crowned
+)
+
+// Brackets a tournament match can be played in.
+const (
+	bracketWinners = "winners" // entrants who haven't lost yet
+	bracketLosers  = "losers"  // double elimination entrants with one loss
+	bracketFinal   = "final"   // the last two entrants of an elimination tournament
+	bracketSwiss   = "swiss"
+)
+
+// tournamentEntrant is a player registered for a tournament with the team
+// they play every round with.
+type tournamentEntrant struct {
+	Name       string   `json:"name"`
+	Seed       int      `json:"seed"`
+	Rating     int      `json:"rating"`
+	Wins       int      `json:"wins"`
+	Losses     int      `json:"losses"`
+	Byes       int      `json:"byes"`
+	Eliminated bool     `json:"eliminated"`
+	Opponents  []string `json:"opponents"`
+
+	members []TeamMember
+}
+
+// tournamentMatch pairs two entrants in a round. A match without a second
+// player is a bye, won straight away.
+type tournamentMatch struct {
+	Round    int    `json:"round"`
+	Bracket  string `json:"bracket"`
+	Player1  string `json:"player1"`
+	Player2  string `json:"player2,omitempty"`
+	BattleID string `json:"battle_id,omitempty"`
+	Winner   string `json:"winner,omitempty"`
+}
+
+type tournament struct {
+	ID        string               `json:"id"`
+	Name      string               `json:"name"`
+	Format    string               `json:"format"`
+	Mode      string               `json:"mode"`
+	Organizer string               `json:"organizer"`
+	Status    string               `json:"status"`
+	Round     int                  `json:"round"`
+	Rounds    int                  `json:"rounds,omitempty"` // Swiss only
+	Entrants  []*tournamentEntrant `json:"entrants"`
+	Matches   []*tournamentMatch   `json:"matches"`
+	Champion  string               `json:"champion,omitempty"`
+	Created   time.Time            `json:"created"`
+}
+
+// tournamentBattle ties a battle to the tournament match it settles.
+type tournamentBattle struct {
+	tournament *tournament
+	match      *tournamentMatch
+}
+
+// tournamentRegistry holds every tournament behind one lock. It may start
+// battles while holding it, so it must never be taken under a room's lock.
+type tournamentRegistry struct {
+	mu          sync.Mutex
+	tournaments map[string]*tournament
+	battles     map[string]tournamentBattle
+}
+
+var tournaments = &tournamentRegistry{
+	tournaments: make(map[string]*tournament),
+	battles:     make(map[string]tournamentBattle),
+}
+
+// tournamentFormat reads a format name, accepting "single", "double" and
+// hyphens for short.
+func tournamentFormat(format string) (string, error) {
+	format = strings.ReplaceAll(strings.ToLower(format), "-", "_")
+	switch format {
+	case "single", formatSingleElimination:
+		return formatSingleElimination, nil
+	case "double", formatDoubleElimination:
+		return formatDoubleElimination, nil
+	case formatSwiss:
+		return formatSwiss, nil
+	}
+	return "", fmt.Errorf("invalid tournament format %s, use %s, %s or %s", format, formatSingleElimination, formatDoubleElimination, formatSwiss)
+}
+
+// maxLosses is how many losses knock an entrant out, or 0 when nobody is
+// knocked out.
+func (t *tournament) maxLosses() int {
+	switch t.Format {
+	case formatSingleElimination:
+		return 1
+	case formatDoubleElimination:
+		return 2
+	}
+	return 0
+}
+
+func (t *tournament) entrant(name string) *tournamentEntrant {
+	for _, entrant := range t.Entrants {
+		if entrant.Name == name {
+			return entrant
+		}
+	}
+	return nil
+}
+
+// remaining returns the entrants still in the tournament, in seed order.
+func (t *tournament) remaining() []*tournamentEntrant {
+	var remaining []*tournamentEntrant
+	for _, entrant := range t.Entrants {
+		if !entrant.Eliminated {
+			remaining = append(remaining, entrant)
+		}
+	}
+	return remaining
+}
+
+func (t *tournament) roundComplete() bool {
+	for _, match := range t.Matches {
+		if match.Round == t.Round && match.Winner == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// over reports whether the last round has been played: one entrant left in
+// an elimination tournament, or every Swiss round done.
+func (t *tournament) over() bool {
+	if t.Format == formatSwiss {
+		return t.Round >= t.Rounds
+	}
+	return len(t.remaining()) <= 1
+}
+
+// recordWin settles a played match, knocking the loser out once they reach the
+// format's loss limit.
+func (t *tournament) recordWin(match *tournamentMatch, winner string) {
+	match.Winner = winner
+	t.entrant(winner).Wins++
+	loserName := match.Player1
+	if loserName == winner {
+		loserName = match.Player2
+	}
+	loser := t.entrant(loserName)
+	loser.Losses++
+	if limit := t.maxLosses(); limit > 0 && loser.Losses >= limit {
+		loser.Eliminated = true
+	}
+}
+
+// takeBye picks who sits out a round of an odd group: the first entrant in
+// order who has had the fewest byes.
+func takeBye(group []*tournamentEntrant, order []int) (*tournamentEntrant, []*tournamentEntrant) {
+	pick := order[0]
+	for _, i := range order {
+		if group[i].Byes < group[pick].Byes {
+			pick = i
+		}
+	}
+	rest := append(append([]*tournamentEntrant(nil), group[:pick]...), group[pick+1:]...)
+	return group[pick], rest
+}
+
+// eliminationPairings pairs the remaining entrants with the same number of
+// losses, best seed against worst, so winners and losers brackets each play
+// among themselves. The last two entrants play the final.
+func (t *tournament) eliminationPairings() []*tournamentMatch {
+	remaining := t.remaining()
+	if len(remaining) == 2 {
+		return []*tournamentMatch{{Bracket: bracketFinal, Player1: remaining[0].Name, Player2: remaining[1].Name}}
+	}
+
+	var matches []*tournamentMatch
+	for losses := 0; losses < t.maxLosses(); losses++ {
+		bracket := bracketWinners
+		if losses > 0 {
+			bracket = bracketLosers
+		}
+		var group []*tournamentEntrant
+		for _, entrant := range remaining {
+			if entrant.Losses == losses {
+				group = append(group, entrant)
+			}
+		}
+		if len(group)%2 == 1 {
+			// The best seed with the fewest byes sits out
+			order := make([]int, len(group))
+			for i := range order {
+				order[i] = i
+			}
+			var bye *tournamentEntrant
+			bye, group = takeBye(group, order)
+			matches = append(matches, &tournamentMatch{Bracket: bracket, Player1: bye.Name})
+		}
+		for i := 0; i < len(group)/2; i++ {
+			matches = append(matches, &tournamentMatch{Bracket: bracket, Player1: group[i].Name, Player2: group[len(group)-1-i].Name})
+		}
+	}
+	return matches
+}
+
+// swissPairings pairs entrants with the same score, avoiding rematches where
+// it can. With an odd number, the lowest-ranked entrant with the fewest byes
+// sits out.
+func (t *tournament) swissPairings() []*tournamentMatch {
+	standings := append([]*tournamentEntrant(nil), t.Entrants...)
+	sort.SliceStable(standings, func(i, j int) bool {
+		return standings[i].Wins > standings[j].Wins
+	})
+
+	var matches []*tournamentMatch
+	if len(standings)%2 == 1 {
+		order := make([]int, len(standings))
+		for i := range order {
+			order[i] = len(standings) - 1 - i
+		}
+		var bye *tournamentEntrant
+		bye, standings = takeBye(standings, order)
+		matches = append(matches, &tournamentMatch{Bracket: bracketSwiss, Player1: bye.Name})
+	}
+
+	paired := make([]bool, len(standings))
+	for i, entrant := range standings {
+		if paired[i] {
+			continue
+		}
+		opponent := -1
+		for j := i + 1; j < len(standings); j++ {
+			if paired[j] {
+				continue
+			}
+			if opponent < 0 {
+				opponent = j
+			}
+			if !slices.Contains(entrant.Opponents, standings[j].Name) {
+				opponent = j
+				break
+			}
+		}
+		paired[i], paired[opponent] = true, true
+		matches = append(matches, &tournamentMatch{Bracket: bracketSwiss, Player1: entrant.Name, Player2: standings[opponent].Name})
+	}
+	return matches
+}
+
+// crown names the champion: the last entrant standing, or the Swiss entrant
+// with the most wins, ties going to the one whose opponents won most and then
+// to the better seed.
+func (t *tournament) crown() {
+	t.Status = tournamentFinished
+	if t.Format != formatSwiss {
+		if remaining := t.remaining(); len(remaining) == 1 {
+			t.Champion = remaining[0].Name
+		}
+		return
+	}
+
+	opponentWins := func(entrant *tournamentEntrant) int {
+		total := 0
+		for _, name := range entrant.Opponents {
+			total += t.entrant(name).Wins
+		}
+		return total
+	}
+	best := t.Entrants[0]
+	for _, entrant := range t.Entrants[1:] {
+		if entrant.Wins > best.Wins || (entrant.Wins == best.Wins && opponentWins(entrant) > opponentWins(best)) {
+			best = entrant
+		}
+	}
+	t.Champion = best.Name
+}
+
+// startMatchLocked opens the battle for a match, or settles it straight away
+// for a bye or an entrant whose team no longer passes the rules. The caller
+// holds the registry's lock.
+func (registry *tournamentRegistry) startMatchLocked(t *tournament, match *tournamentMatch) {
+	if match.Player2 == "" {
+		// A bye scores as a win in Swiss, and only moves the entrant on
+		// otherwise
+		entrant := t.entrant(match.Player1)
+		entrant.Byes++
+		if t.Format == formatSwiss {
+			entrant.Wins++
+		}
+		match.Winner = entrant.Name
+		return
+	}
+	entrant1, entrant2 := t.entrant(match.Player1), t.entrant(match.Player2)
+	entrant1.Opponents = append(entrant1.Opponents, entrant2.Name)
+	entrant2.Opponents = append(entrant2.Opponents, entrant1.Name)
+
+	team1, keys1, problems1 := buildValidTeam(entrant1.Name, "pokemon", entrant1.members)
+	team2, keys2, problems2 := buildValidTeam(entrant2.Name, "pokemon", entrant2.members)
+	switch {
+	case len(problems1) > 0:
+		log.Printf("Tournament %s: %s's team no longer passes the rules, %s wins", t.ID, entrant1.Name, entrant2.Name)
+		t.recordWin(match, entrant2.Name)
+		return
+	case len(problems2) > 0:
+		log.Printf("Tournament %s: %s's team no longer passes the rules, %s wins", t.ID, entrant2.Name, entrant1.Name)
+		t.recordWin(match, entrant1.Name)
+		return
+	}
+
+	room := startMatchedBattle(t.Mode,
+		gameplay.Player{Name: entrant1.Name, Pokemon: team1},
+		gameplay.Player{Name: entrant2.Name, Pokemon: team2},
+		keys1, keys2)
+	room.mu.Lock()
+	room.tournament = true
+	room.mu.Unlock()
+	match.BattleID = room.battle.ID
+	registry.battles[match.BattleID] = tournamentBattle{tournament: t, match: match}
+}
+
+// advanceLocked plays the tournament on for as long as its current round is
+// complete: the next round is paired and started, or the champion crowned.
+// The caller holds the registry's lock.
+func (registry *tournamentRegistry) advanceLocked(t *tournament) {
+	for t.Status == tournamentRunning && t.roundComplete() {
+		if t.Round > 0 && t.over() {
+			t.crown()
+			log.Printf("Tournament %s is over, %s is the champion", t.ID, t.Champion)
+			return
+		}
+
+		t.Round++
+		pairings := t.eliminationPairings()
+		if t.Format == formatSwiss {
+			pairings = t.swissPairings()
+		}
+		log.Printf("Tournament %s: starting round %d with %d matches", t.ID, t.Round, len(pairings))
+		for _, match := range pairings {
+			match.Round = t.Round
+			t.Matches = append(t.Matches, match)
+			registry.startMatchLocked(t, match)
+		}
+	}
+}
+
+// battleFinished settles the tournament match a finished battle was played
+// for, if any. It takes the registry's lock and may start battles, so it is
+// run apart from the room the battle finished in.
+func (registry *tournamentRegistry) battleFinished(result battleResult) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	entry, ok := registry.battles[result.BattleID]
+	if !ok || entry.match.Winner != "" {
+		return
+	}
+	delete(registry.battles, result.BattleID)
+	entry.tournament.recordWin(entry.match, result.WinnerName)
+	log.Printf("Tournament %s: %s won their round %d match", entry.tournament.ID, result.WinnerName, entry.match.Round)
+	registry.advanceLocked(entry.tournament)
+}
+
+// writeTournament answers with the tournament's current state. The caller
+// holds the registry's lock.
+func writeTournament(w http.ResponseWriter, t *tournament) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// findTournament looks up the tournament named in the request path. The
+// caller holds the registry's lock.
+func findTournament(w http.ResponseWriter, r *http.Request) (*tournament, bool) {
+	t, ok := tournaments.tournaments[r.PathValue("id")]
+	if !ok {
+		http.Error(w, "Tournament not found", http.StatusNotFound)
+	}
+	return t, ok
+}
+
+// tournamentSummary is how a tournament appears in the list of tournaments.
+type tournamentSummary struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Format   string    `json:"format"`
+	Mode     string    `json:"mode"`
+	Status   string    `json:"status"`
+	Round    int       `json:"round"`
+	Entrants int       `json:"entrants"`
+	Champion string    `json:"champion,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// Handle tournaments: GET lists them, newest first, and POST creates one
+// run by the player
+func handleTournaments(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		tournaments.mu.Lock()
+		summaries := []tournamentSummary{}
+		for _, t := range tournaments.tournaments {
+			summaries = append(summaries, tournamentSummary{
+				ID:       t.ID,
+				Name:     t.Name,
+				Format:   t.Format,
+				Mode:     t.Mode,
+				Status:   t.Status,
+				Round:    t.Round,
+				Entrants: len(t.Entrants),
+				Champion: t.Champion,
+				Created:  t.Created,
+			})
+		}
+		tournaments.mu.Unlock()
+		sort.Slice(summaries, func(i, j int) bool { return summaries[i].Created.After(summaries[j].Created) })
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summaries)
+	case http.MethodPost:
+		createTournament(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func createTournament(w http.ResponseWriter, r *http.Request) {
+	name, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var tournamentRequest struct {
+		Name   string `json:"name"`
+		Format string `json:"format"`
+		Mode   string `json:"mode"`
+		Rounds int    `json:"rounds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&tournamentRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	format, err := tournamentFormat(tournamentRequest.Format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mode, err := battleMode(tournamentRequest.Mode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if tournamentRequest.Rounds < 0 || (tournamentRequest.Rounds > 0 && format != formatSwiss) {
+		http.Error(w, "Only Swiss tournaments take a number of rounds", http.StatusBadRequest)
+		return
+	}
+	if tournamentRequest.Name == "" {
+		tournamentRequest.Name = name + "'s tournament"
+	}
+
+	t := &tournament{
+		ID:        newID(),
+		Name:      tournamentRequest.Name,
+		Format:    format,
+		Mode:      mode,
+		Organizer: name,
+		Status:    tournamentRegistering,
+		Rounds:    tournamentRequest.Rounds,
+		Entrants:  []*tournamentEntrant{},
+		Matches:   []*tournamentMatch{},
+		Created:   time.Now(),
+	}
+	tournaments.mu.Lock()
+	defer tournaments.mu.Unlock()
+	tournaments.tournaments[t.ID] = t
+	log.Printf("%s created %s tournament %s", name, format, t.ID)
+	writeTournament(w, t)
+}
+
+// Handle fetching a tournament's bracket GET method
+func handleTournament(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tournaments.mu.Lock()
+	defer tournaments.mu.Unlock()
+	if t, ok := findTournament(w, r); ok {
+		writeTournament(w, t)
+	}
+}
+
+// Handle a player entering a tournament with the team they play every round
+// with
+func handleTournamentRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+	var registerRequest struct {
+		Pokemon []TeamMember `json:"pokemon"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&registerRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, _, problems := buildValidTeam(name, "pokemon", registerRequest.Pokemon); len(problems) > 0 {
+		writeTeamProblems(w, problems)
+		return
+	}
+
+	tournaments.mu.Lock()
+	defer tournaments.mu.Unlock()
+	t, ok := findTournament(w, r)
+	if !ok {
+		return
+	}
+	switch {
+	case t.Status != tournamentRegistering:
+		http.Error(w, "The tournament has already started", http.StatusConflict)
+		return
+	case t.entrant(name) != nil:
+		http.Error(w, "You are already registered", http.StatusConflict)
+		return
+	}
+	t.Entrants = append(t.Entrants, &tournamentEntrant{
+		Name:      name,
+		Rating:    ratings.rating(name),
+		Opponents: []string{},
+		members:   registerRequest.Pokemon,
+	})
+	log.Printf("%s registered for tournament %s", name, t.ID)
+	writeTournament(w, t)
+}
+
+// Handle the organizer closing registration and starting the first round.
+// Entrants are seeded by rating.
+func handleTournamentStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+	tournaments.mu.Lock()
+	defer tournaments.mu.Unlock()
+	t, ok := findTournament(w, r)
+	if !ok {
+		return
+	}
+	switch {
+	case t.Organizer != name:
+		http.Error(w, "Only the organizer can start the tournament", http.StatusForbidden)
+		return
+	case t.Status != tournamentRegistering:
+		http.Error(w, "The tournament has already started", http.StatusConflict)
+		return
+	case len(t.Entrants) < 2:
+		http.Error(w, "A tournament needs at least two entrants", http.StatusConflict)
+		return
+	}
+
+	sort.SliceStable(t.Entrants, func(i, j int) bool {
+		return t.Entrants[i].Rating > t.Entrants[j].Rating
+	})
+	for i, entrant := range t.Entrants {
+		entrant.Seed = i + 1
+	}
+	if t.Format == formatSwiss && t.Rounds == 0 {
+		// Enough rounds for an unbeaten winner to emerge
+		t.Rounds = bits.Len(uint(len(t.Entrants) - 1))
+	}
+	t.Status = tournamentRunning
+	log.Printf("%s started tournament %s with %d entrants", name, t.ID, len(t.Entrants))
+	tournaments.advanceLocked(t)
+	writeTournament(w, t)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"netcentric/gameplay"
+	"testing"
+	"time"
+)
+
+func TestAbandonedTournamentBattleIsSettled(t *testing.T) {
+	team := []TeamMember{{Name: "Squirtle"}, {Name: "Pikachu"}}
+	tourney := &tournament{
+		ID:     newID(),
+		Format: formatSingleElimination,
+		Mode:   gameplay.ModeTurns,
+		Status: tournamentRunning,
+		Entrants: []*tournamentEntrant{
+			{Name: "abandon-one", Seed: 1, members: team},
+			{Name: "abandon-two", Seed: 2, members: team},
+		},
+	}
+	tournaments.mu.Lock()
+	tournaments.tournaments[tourney.ID] = tourney
+	tournaments.advanceLocked(tourney)
+	battleID := tourney.Matches[0].BattleID
+	tournaments.mu.Unlock()
+
+	room, ok := rooms.get(battleID)
+	if !ok {
+		t.Fatalf("Tournament battle %s has no room", battleID)
+	}
+	// Nobody plays the first turn, which belongs to the first player
+	room.mu.Lock()
+	room.lastActivity = time.Now().Add(-2 * abandonedBattleTTL)
+	room.mu.Unlock()
+	rooms.cleanup(time.Now())
+
+	if _, ok := rooms.get(battleID); ok {
+		t.Errorf("Abandoned battle %s was not dropped", battleID)
+	}
+	// The result is recorded apart from the cleanup
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tournaments.mu.Lock()
+		status, champion := tourney.Status, tourney.Champion
+		tournaments.mu.Unlock()
+		if status == tournamentFinished {
+			if champion != "abandon-two" {
+				t.Errorf("Champion is %q, want abandon-two", champion)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Tournament is still %s after its only battle was abandoned", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
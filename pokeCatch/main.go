@@ -11,11 +11,15 @@ import (
 	"os/exec"
 	"strings"
 	"time"
+	"netcentric/storage"
 	"netcentric/utils" 
 )
 
 const baseURL = "https://pokeapi.co/api/v1"
 
+// The trainer catching Pokémon, unless one is named on the command line
+const defaultTrainer = "trainer"
+
 type Region struct {
 	Name      string `json:"name"`
 	Locations []struct {
@@ -184,6 +188,19 @@ func openImage(filepath string) error {
 }
 
 func main() {
+	trainer := defaultTrainer
+	if len(os.Args) > 1 {
+		trainer = os.Args[1]
+	}
+
+	// Open the store the caught Pokémon are kept in
+	db, err := storage.Open()
+	if err != nil {
+		fmt.Printf("Error opening store: %v\n", err)
+		return
+	}
+	defer db.Close()
+
 	// Step 1: Fetch and list all regions
 	regions, err := getRegions()
 	if err != nil {
@@ -245,6 +262,26 @@ func main() {
 		fmt.Printf("- %s: %d\n", stat.Stat.Name, stat.BaseStat)
 	}
 
+	// Catch it and keep it in the trainer's collection
+	caught := storage.CaughtPokemon{
+		Player:   trainer,
+		Species:  encounteredPokemon.Name,
+		Region:   selectedRegion,
+		Location: selectedLocation,
+		Area:     selectedArea,
+		Sprite:   encounteredPokemon.Sprites.FrontDefault,
+		Stats:    make(map[string]int),
+		CaughtAt: time.Now(),
+	}
+	for _, stat := range encounteredPokemon.Stats {
+		caught.Stats[stat.Stat.Name] = stat.BaseStat
+	}
+	if err := storage.AddCaught(db, &caught); err != nil {
+		fmt.Printf("Error saving caught Pokémon: %v\n", err)
+		return
+	}
+	fmt.Printf("%s caught the %s! (#%d)\n", trainer, caught.Species, caught.Number)
+
 	// Step 5: Download and open the Pokémon image
 	imageURL := encounteredPokemon.Sprites.FrontDefault
 	filepath := "pokemon_image.png"
@@ -8,9 +8,14 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"netcentric/storage"
 	"netcentric/utils"
 )
 
+// The store shared with the battle server and pokeCatch, holding the Pokémon
+// each player has caught
+var db storage.Store
+
 type Pokemon struct {
 	Name   string `json:"name"`
 	Height int    `json:"height"`
@@ -127,10 +132,50 @@ func handlePokemonRequest(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Successfully responded with data for %s", name)
 }
 
+// Handler for listing the Pokémon a player has caught
+func handleCollectionRequest(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received %s request for %s", r.Method, r.URL.Path)
+
+	// Ensure method is GET
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+		return
+	}
+
+	// Extract 'player' query parameter
+	player := r.URL.Query().Get("player")
+	if player == "" {
+		http.Error(w, "Missing player name", http.StatusBadRequest)
+		log.Printf("Error: Missing player name in request")
+		return
+	}
+
+	caught, err := storage.CaughtBy(db, player)
+	if err != nil {
+		http.Error(w, "Failed to load caught Pokémon", http.StatusInternalServerError)
+		log.Printf("Error loading the Pokémon %s caught: %v", player, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(caught); err != nil {
+		log.Printf("Error encoding collection of %s: %v", player, err)
+	}
+	log.Printf("Successfully responded with the %d Pokémon %s caught", len(caught), player)
+}
+
 // Main function to start the server
 func main() {
-	// Handle requests at '/pokemon'
+	// Open the shared store
+	var err error
+	if db, err = storage.Open(); err != nil {
+		log.Fatalf("Failed to open store: %v", err)
+	}
+
+	// Handle requests at '/pokemon' and '/collection'
 	http.HandleFunc("/pokemon", handlePokemonRequest)
+	http.HandleFunc("/collection", handleCollectionRequest)
 
 	// Start the server
 	log.Printf("Starting server on :8080...")
@@ -0,0 +1,575 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// How long a transaction waits for another program to finish with the
+	// store. The lock is the OS's, so a program that dies lets go of it.
+	lockTimeout = 15 * time.Second
+	lockRetry   = 10 * time.Millisecond
+)
+
+// fileData is everything in a file store: the snapshot at the start of the
+// file with the transactions appended after it applied.
+type fileData struct {
+	Version int                `json:"version"`
+	Buckets map[string]*bucket `json:"buckets"`
+}
+
+type bucket struct {
+	Sequence uint64                     `json:"sequence"`
+	Items    map[string]json.RawMessage `json:"items"`
+}
+
+// fileRecord is a transaction appended to the file after the snapshot.
+type fileRecord struct {
+	Buckets map[string]*bucketChange `json:"buckets"`
+}
+
+// bucketChange is what a transaction did to one bucket.
+type bucketChange struct {
+	Created  bool                       `json:"created,omitempty"`
+	Sequence uint64                     `json:"sequence"`
+	Put      map[string]json.RawMessage `json:"put,omitempty"`
+	Deleted  []string                   `json:"deleted,omitempty"`
+}
+
+// FileStore is a store kept in a single JSON file: a snapshot of every
+// bucket followed by the transactions committed since, one per line. A
+// transaction is appended to the file, and once the transactions outgrow the
+// snapshot the file is compacted, replacing it whole with a new snapshot.
+// Programs sharing the store hold an OS lock on a lock file beside it while
+// they read or write the file, shared for reading and exclusive for writing.
+type FileStore struct {
+	mu       sync.RWMutex
+	path     string
+	lockFile *os.File
+	data     *fileData
+
+	// The file as it was last read or written, kept open so its identity
+	// tells when another program has compacted it, and how much of it is
+	// the snapshot and the complete transactions read so far
+	file         *os.File
+	info         os.FileInfo
+	size         int64
+	snapshotSize int64
+}
+
+// OpenFile opens the file store at path, creating it if needed, and migrates
+// it to the current schema.
+func OpenFile(path string) (*FileStore, error) {
+	lockFile, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the lock file of store %s: %v", path, err)
+	}
+	store := &FileStore{path: path, lockFile: lockFile, data: &fileData{Buckets: make(map[string]*bucket)}}
+	err = store.locked(syscall.LOCK_EX, func() error {
+		if err := store.reload(); err != nil {
+			return err
+		}
+		return store.migrate()
+	})
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// lock takes the OS lock on the lock file beside the store, shared
+// (syscall.LOCK_SH) or exclusive (syscall.LOCK_EX).
+func (store *FileStore) lock(how int) error {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err := syscall.Flock(int(store.lockFile.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			return nil
+		}
+		if err != syscall.EWOULDBLOCK && err != syscall.EINTR {
+			return fmt.Errorf("failed to lock store %s: %v", store.path, err)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for the lock on store %s", store.path)
+		}
+		time.Sleep(lockRetry)
+	}
+}
+
+func (store *FileStore) unlock() {
+	if err := syscall.Flock(int(store.lockFile.Fd()), syscall.LOCK_UN); err != nil {
+		log.Printf("Failed to unlock store %s: %v", store.path, err)
+	}
+}
+
+// locked runs fn holding both the store's mutex and the OS lock.
+func (store *FileStore) locked(how int, fn func() error) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if err := store.lock(how); err != nil {
+		return err
+	}
+	defer store.unlock()
+	return fn()
+}
+
+// changed reports whether the file differs from the one last read or
+// written. The caller holds the store's mutex.
+func (store *FileStore) changed() (bool, error) {
+	info, err := os.Stat(store.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check store %s: %v", store.path, err)
+	}
+	return store.info == nil || !os.SameFile(info, store.info) || info.Size() != store.size, nil
+}
+
+// reload reads what another program has written since the file was last
+// read: the transactions it appended, or the whole file if it was compacted.
+// The caller holds the store's mutex for writing and the OS lock.
+func (store *FileStore) reload() error {
+	changed, err := store.changed()
+	if err != nil || !changed {
+		return err
+	}
+	info, err := os.Stat(store.path)
+	if err != nil {
+		return fmt.Errorf("failed to check store %s: %v", store.path, err)
+	}
+	if store.info != nil && os.SameFile(info, store.info) {
+		return store.read(store.file, store.size, store.data)
+	}
+
+	file, err := os.OpenFile(store.path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open store %s: %v", store.path, err)
+	}
+	if err := store.read(file, 0, nil); err != nil {
+		file.Close()
+		return err
+	}
+	if store.file != nil {
+		store.file.Close()
+	}
+	store.file = file
+	return nil
+}
+
+// read reads the file from offset: its snapshot first if data is nil, then
+// the transactions after it. An unfinished transaction at the end, left by a
+// program that died writing it, is ignored and overwritten by the next one.
+// The caller holds the store's mutex for writing and the OS lock.
+func (store *FileStore) read(file *os.File, offset int64, data *fileData) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to check store %s: %v", store.path, err)
+	}
+	raw := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(raw, offset); err != nil {
+		return fmt.Errorf("failed to read store %s: %v", store.path, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	snapshotSize := store.snapshotSize
+	if data == nil {
+		data = &fileData{}
+		if err := decoder.Decode(data); err != nil {
+			return fmt.Errorf("failed to decode store %s: %v", store.path, err)
+		}
+		if data.Buckets == nil {
+			data.Buckets = make(map[string]*bucket)
+		}
+		snapshotSize = decoder.InputOffset()
+	}
+	tx := &fileTx{data: data, writable: true}
+	read := decoder.InputOffset()
+	for {
+		var record fileRecord
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			read = int64(len(raw))
+			break
+		}
+		if err != nil {
+			log.Printf("Ignoring an unfinished transaction at the end of store %s: %v", store.path, err)
+			break
+		}
+		if err := tx.apply(&record); err != nil {
+			return fmt.Errorf("failed to read store %s: %v", store.path, err)
+		}
+		read = decoder.InputOffset()
+	}
+
+	store.data, store.info = tx.merged(data.Version), info
+	store.size, store.snapshotSize = offset+read, snapshotSize
+	return nil
+}
+
+// save compacts the data into a new file, written to a temporary file and
+// renamed over the store. The caller holds the store's mutex and the OS
+// lock.
+func (store *FileStore) save(data *fileData) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode store %s: %v", store.path, err)
+	}
+	raw = append(raw, '\n')
+	temp, err := os.CreateTemp(filepath.Dir(store.path), filepath.Base(store.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save store %s: %v", store.path, err)
+	}
+	_, err = temp.Write(raw)
+	if err == nil {
+		err = os.Rename(temp.Name(), store.path)
+	}
+	if err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to save store %s: %v", store.path, err)
+	}
+
+	info, err := temp.Stat()
+	if err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to check store %s: %v", store.path, err)
+	}
+	if store.file != nil {
+		store.file.Close()
+	}
+	store.file, store.info, store.data = temp, info, data
+	store.size, store.snapshotSize = info.Size(), info.Size()
+	return nil
+}
+
+// appendRecord writes a transaction to the end of the file, over anything an
+// unfinished write left after the last complete one. The caller holds the
+// store's mutex and the OS lock.
+func (store *FileStore) appendRecord(raw []byte) error {
+	if err := store.file.Truncate(store.size); err != nil {
+		return fmt.Errorf("failed to save store %s: %v", store.path, err)
+	}
+	if _, err := store.file.WriteAt(raw, store.size); err != nil {
+		return fmt.Errorf("failed to save store %s: %v", store.path, err)
+	}
+	info, err := store.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to check store %s: %v", store.path, err)
+	}
+	store.info, store.size = info, store.size+int64(len(raw))
+	return nil
+}
+
+func (store *FileStore) View(fn func(tx Tx) error) error {
+	store.mu.RLock()
+	changed, err := store.changed()
+	store.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if changed {
+		if err := store.locked(syscall.LOCK_SH, store.reload); err != nil {
+			return err
+		}
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return fn(&fileTx{data: store.data})
+}
+
+func (store *FileStore) Update(fn func(tx Tx) error) error {
+	return store.locked(syscall.LOCK_EX, func() error {
+		if err := store.reload(); err != nil {
+			return err
+		}
+		return store.commit(fn)
+	})
+}
+
+// commit runs fn against a copy of the data and, if fn succeeds, appends
+// what it changed to the file, or compacts the file if the transactions
+// after the snapshot would outgrow it. The caller holds the store's mutex
+// and the OS lock.
+func (store *FileStore) commit(fn func(tx Tx) error) error {
+	tx := &fileTx{data: store.data, writable: true}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if tx.written == nil {
+		return nil
+	}
+	data := tx.merged(store.data.Version)
+	raw, err := json.Marshal(tx.record())
+	if err != nil {
+		return fmt.Errorf("failed to encode store %s: %v", store.path, err)
+	}
+	raw = append(raw, '\n')
+	if store.file == nil || store.size-store.snapshotSize+int64(len(raw)) > store.snapshotSize {
+		return store.save(data)
+	}
+	if err := store.appendRecord(raw); err != nil {
+		return err
+	}
+	store.data = data
+	return nil
+}
+
+// migrate runs every migration the store hasn't had yet, saving after each
+// one. The caller holds the store's mutex and the OS lock.
+func (store *FileStore) migrate() error {
+	version := store.data.Version
+	if version > len(migrations) {
+		return fmt.Errorf("store %s is at schema version %d, this program knows up to %d: %w",
+			store.path, version, len(migrations), ErrNewerVersion)
+	}
+	for ; version < len(migrations); version++ {
+		migration := migrations[version]
+		tx := &fileTx{data: store.data, writable: true}
+		if err := migration.up(tx); err != nil {
+			return fmt.Errorf("failed to migrate store %s to schema version %d: %v", store.path, version+1, err)
+		}
+		if err := store.save(tx.merged(version + 1)); err != nil {
+			return err
+		}
+		log.Printf("Migrated store %s to schema version %d: %s", store.path, version+1, migration.description)
+	}
+	return nil
+}
+
+func (store *FileStore) Version() int {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.data.Version
+}
+
+// Close closes the store's file and lock file.
+func (store *FileStore) Close() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var err error
+	if store.file != nil {
+		err = store.file.Close()
+		store.file, store.info = nil, nil
+	}
+	if closeErr := store.lockFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// fileTx is a transaction on a file store. A writable transaction copies a
+// bucket the first time it changes it, so the store's data is untouched
+// until the transaction is saved, and notes the buckets it created and the
+// keys it changed, which are what is appended to the file.
+type fileTx struct {
+	data     *fileData
+	writable bool
+	written  map[string]*bucket
+	created  map[string]bool
+	changed  map[string]map[string]bool // bucket -> keys
+}
+
+// record returns what the transaction changed, to append to the file.
+func (tx *fileTx) record() *fileRecord {
+	record := &fileRecord{Buckets: make(map[string]*bucketChange, len(tx.written))}
+	for name, b := range tx.written {
+		change := &bucketChange{Created: tx.created[name], Sequence: b.Sequence}
+		for key := range tx.changed[name] {
+			if value, ok := b.Items[key]; ok {
+				if change.Put == nil {
+					change.Put = make(map[string]json.RawMessage)
+				}
+				change.Put[key] = value
+			} else {
+				change.Deleted = append(change.Deleted, key)
+			}
+		}
+		record.Buckets[name] = change
+	}
+	return record
+}
+
+// apply makes the changes of a transaction read from the file.
+func (tx *fileTx) apply(record *fileRecord) error {
+	for name, change := range record.Buckets {
+		if change.Created {
+			if err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		b, err := tx.writableBucket(name)
+		if err != nil {
+			return err
+		}
+		b.Sequence = change.Sequence
+		maps.Copy(b.Items, change.Put)
+		for _, key := range change.Deleted {
+			delete(b.Items, key)
+		}
+	}
+	return nil
+}
+
+// touch notes that the transaction changed the key.
+func (tx *fileTx) touch(bucketName, key string) {
+	if tx.changed == nil {
+		tx.changed = make(map[string]map[string]bool)
+	}
+	if tx.changed[bucketName] == nil {
+		tx.changed[bucketName] = make(map[string]bool)
+	}
+	tx.changed[bucketName][key] = true
+}
+
+// merged returns the data with the buckets the transaction changed.
+func (tx *fileTx) merged(version int) *fileData {
+	data := &fileData{Version: version, Buckets: maps.Clone(tx.data.Buckets)}
+	maps.Copy(data.Buckets, tx.written)
+	return data
+}
+
+func (tx *fileTx) bucket(name string) (*bucket, error) {
+	if b, ok := tx.written[name]; ok {
+		return b, nil
+	}
+	if b, ok := tx.data.Buckets[name]; ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("bucket %s: %w", name, ErrNoBucket)
+}
+
+// writableBucket returns the transaction's own copy of the bucket.
+func (tx *fileTx) writableBucket(name string) (*bucket, error) {
+	if !tx.writable {
+		return nil, ErrReadOnly
+	}
+	b, err := tx.bucket(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := tx.written[name]; !ok {
+		if tx.written == nil {
+			tx.written = make(map[string]*bucket)
+		}
+		b = &bucket{Sequence: b.Sequence, Items: make(map[string]json.RawMessage, len(b.Items))}
+		maps.Copy(b.Items, tx.data.Buckets[name].Items)
+		tx.written[name] = b
+	}
+	return b, nil
+}
+
+func (tx *fileTx) Get(bucketName, key string, v interface{}) error {
+	b, err := tx.bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	value, ok := b.Items[key]
+	if !ok {
+		return fmt.Errorf("%s %s: %w", bucketName, key, ErrNotFound)
+	}
+	if err := json.Unmarshal(value, v); err != nil {
+		return fmt.Errorf("failed to decode %s %s: %v", bucketName, key, err)
+	}
+	return nil
+}
+
+func (tx *fileTx) Put(bucketName, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s %s: %v", bucketName, key, err)
+	}
+	b, err := tx.writableBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	b.Items[key] = value
+	tx.touch(bucketName, key)
+	return nil
+}
+
+func (tx *fileTx) Delete(bucketName, key string) error {
+	b, err := tx.writableBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	delete(b.Items, key)
+	tx.touch(bucketName, key)
+	return nil
+}
+
+func (tx *fileTx) ForEach(bucketName, prefix string, fn func(key string, value json.RawMessage) error) error {
+	return tx.forEach(bucketName, prefix, false, fn)
+}
+
+func (tx *fileTx) ForEachReverse(bucketName, prefix string, fn func(key string, value json.RawMessage) error) error {
+	return tx.forEach(bucketName, prefix, true, fn)
+}
+
+func (tx *fileTx) forEach(bucketName, prefix string, reverse bool, fn func(key string, value json.RawMessage) error) error {
+	b, err := tx.bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(b.Items))
+	for key := range b.Items {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	if reverse {
+		slices.Reverse(keys)
+	}
+	for _, key := range keys {
+		err := fn(key, b.Items[key])
+		if err == Stop {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *fileTx) NextSequence(bucketName string) (uint64, error) {
+	b, err := tx.writableBucket(bucketName)
+	if err != nil {
+		return 0, err
+	}
+	b.Sequence++
+	return b.Sequence, nil
+}
+
+func (tx *fileTx) CreateBucket(bucketName string) error {
+	if !tx.writable {
+		return ErrReadOnly
+	}
+	if _, err := tx.bucket(bucketName); err == nil {
+		return fmt.Errorf("bucket %s: %w", bucketName, ErrExists)
+	}
+	if tx.written == nil {
+		tx.written = make(map[string]*bucket)
+	}
+	tx.written[bucketName] = &bucket{Items: make(map[string]json.RawMessage)}
+	if tx.created == nil {
+		tx.created = make(map[string]bool)
+	}
+	tx.created[bucketName] = true
+	return nil
+}
@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func openTestStore(t *testing.T, path string) *FileStore {
+	t.Helper()
+	store, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// keys lists the keys ForEach or ForEachReverse visits in a bucket.
+func keys(t *testing.T, store Store, reverse bool, bucket, prefix string) []string {
+	t.Helper()
+	var visited []string
+	err := store.View(func(tx Tx) error {
+		each := tx.ForEach
+		if reverse {
+			each = tx.ForEachReverse
+		}
+		return each(bucket, prefix, func(key string, value json.RawMessage) error {
+			visited = append(visited, key)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("Failed to list %s: %v", bucket, err)
+	}
+	return visited
+}
+
+func TestTransactions(t *testing.T) {
+	store := openTestStore(t, filepath.Join(t.TempDir(), "pokemon.db"))
+
+	err := store.Update(func(tx Tx) error {
+		for _, key := range []string{"b/1", "a/2", "a/1"} {
+			if err := tx.Put(Settings, key, key); err != nil {
+				return err
+			}
+		}
+		// A transaction reads its own writes
+		var value string
+		if err := tx.Get(Settings, "a/1", &value); err != nil || value != "a/1" {
+			t.Errorf("Read back %q, %v within the transaction", value, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	if got := strings.Join(keys(t, store, false, Settings, ""), " "); got != "a/1 a/2 b/1" {
+		t.Errorf("ForEach visited %s", got)
+	}
+	if got := strings.Join(keys(t, store, true, Settings, "a/"), " "); got != "a/2 a/1" {
+		t.Errorf("ForEachReverse with a prefix visited %s", got)
+	}
+	var visited int
+	store.View(func(tx Tx) error {
+		return tx.ForEach(Settings, "", func(key string, value json.RawMessage) error {
+			visited++
+			return Stop
+		})
+	})
+	if visited != 1 {
+		t.Errorf("ForEach went on for %d keys after Stop", visited)
+	}
+
+	// A failed transaction changes nothing
+	failed := errors.New("failed")
+	err = store.Update(func(tx Tx) error {
+		tx.Delete(Settings, "a/1")
+		tx.Put(Settings, "c/1", "c/1")
+		return failed
+	})
+	if err != failed {
+		t.Errorf("Failed update returned %v", err)
+	}
+	if got := strings.Join(keys(t, store, false, Settings, ""), " "); got != "a/1 a/2 b/1" {
+		t.Errorf("After a failed update the bucket holds %s", got)
+	}
+
+	err = store.View(func(tx Tx) error {
+		var value string
+		if err := tx.Get(Settings, "missing", &value); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get of a missing key returned %v", err)
+		}
+		if err := tx.Put(Settings, "a/1", "changed"); err != ErrReadOnly {
+			t.Errorf("Put in a view returned %v", err)
+		}
+		if _, err := tx.NextSequence(Settings); err != ErrReadOnly {
+			t.Errorf("NextSequence in a view returned %v", err)
+		}
+		if err := tx.Get("missing", "a/1", &value); !errors.Is(err, ErrNoBucket) {
+			t.Errorf("Get from a missing bucket returned %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View failed: %v", err)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pokemon.db")
+	store := openTestStore(t, path)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to check store: %v", err)
+	}
+
+	// Small transactions are appended to the file rather than rewriting it
+	var sequence uint64
+	for i := 0; i < 3; i++ {
+		err := store.Update(func(tx Tx) error {
+			n, err := tx.NextSequence(Caught)
+			sequence = n
+			if err != nil {
+				return err
+			}
+			return tx.Put(Caught, SequenceKey(n), n)
+		})
+		if err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+	}
+	if err := store.Update(func(tx Tx) error { return tx.Delete(Caught, SequenceKey(1)) }); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if appended, err := os.Stat(path); err != nil || !os.SameFile(info, appended) {
+		t.Errorf("The store was rewritten for a small transaction")
+	}
+
+	check := func(store Store) {
+		t.Helper()
+		if got := strings.Join(keys(t, store, false, Caught, ""), " "); got != SequenceKey(2)+" "+SequenceKey(3) {
+			t.Errorf("Reopened store holds %s", got)
+		}
+		store.Update(func(tx Tx) error {
+			if n, _ := tx.NextSequence(Caught); n != sequence+1 {
+				t.Errorf("Next sequence is %d, want %d", n, sequence+1)
+			}
+			return errors.New("roll back")
+		})
+	}
+	check(openTestStore(t, path))
+
+	// Once the transactions outgrow the snapshot, the file is compacted
+	for i := 0; i < 100; i++ {
+		if err := store.Update(func(tx Tx) error { return tx.Put(Settings, "setting", i) }); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+	}
+	if compacted, err := os.Stat(path); err != nil || os.SameFile(info, compacted) {
+		t.Errorf("The store was never compacted")
+	}
+	check(openTestStore(t, path))
+}
+
+func TestUnfinishedTransaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pokemon.db")
+	store := openTestStore(t, path)
+	if err := store.Update(func(tx Tx) error { return tx.Put(Settings, "kept", true) }); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	// A program died halfway through appending a transaction
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	file.WriteString(`{"buckets":{"settings":{"sequence":0,"put":{"lost"`)
+	file.Close()
+
+	reopened := openTestStore(t, path)
+	if err := reopened.Update(func(tx Tx) error { return tx.Put(Settings, "after", true) }); err != nil {
+		t.Fatalf("Update after an unfinished transaction failed: %v", err)
+	}
+	if got := strings.Join(keys(t, openTestStore(t, path), false, Settings, ""), " "); got != "after kept" {
+		t.Errorf("Store holds %s, want after kept", got)
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pokemon.db")
+	// Two handles on the same file stand in for two programs
+	stores := []*FileStore{openTestStore(t, path), openTestStore(t, path)}
+
+	const increments = 50
+	var wg sync.WaitGroup
+	for _, store := range stores {
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < increments; j++ {
+					err := store.Update(func(tx Tx) error {
+						var count int
+						if err := tx.Get(Settings, "count", &count); err != nil && !errors.Is(err, ErrNotFound) {
+							return err
+						}
+						return tx.Put(Settings, "count", count+1)
+					})
+					if err != nil {
+						t.Errorf("Update failed: %v", err)
+						return
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	for i, store := range stores {
+		var count int
+		if err := store.View(func(tx Tx) error { return tx.Get(Settings, "count", &count) }); err != nil {
+			t.Fatalf("View failed: %v", err)
+		}
+		if count != 4*increments {
+			t.Errorf("Store %d counted %d increments, want %d", i, count, 4*increments)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// migration brings a store from one schema version to the next. The version
+// a migration moves the store to is its position in migrations, counting
+// from 1, so migrations are only ever appended.
+type migration struct {
+	description string
+	up          func(tx Tx) error
+}
+
+var migrations = []migration{
+	{
+		description: "create the players, caught, progress, battles and ratings buckets",
+		up: func(tx Tx) error {
+			for _, name := range []string{Players, Caught, Progress, Battles, Ratings, RatedMatches} {
+				if err := tx.CreateBucket(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		description: "keep replays apart from battle records and index battles in the order they ended",
+		up:          splitReplays,
+	},
+	{
+		description: "create the settings bucket",
+		up: func(tx Tx) error {
+			return tx.CreateBucket(Settings)
+		},
+	},
+}
+
+// splitReplays moves each battle's replay out of its record into the replays
+// bucket, so listing battles doesn't load every replay, and indexes the
+// battles by when they ended.
+func splitReplays(tx Tx) error {
+	for _, name := range []string{Replays, BattleIndex} {
+		if err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+
+	type ended struct {
+		endedAt time.Time
+		entry   BattleIndexEntry
+	}
+	var battles []ended
+	err := tx.ForEach(Battles, "", func(key string, value json.RawMessage) error {
+		var record map[string]json.RawMessage
+		var summary struct {
+			EndedAt time.Time `json:"ended_at"`
+			Players []struct {
+				Name string `json:"name"`
+			} `json:"players"`
+		}
+		if err := json.Unmarshal(value, &record); err != nil {
+			return fmt.Errorf("failed to decode %s %s: %v", Battles, key, err)
+		}
+		if err := json.Unmarshal(value, &summary); err != nil {
+			return fmt.Errorf("failed to decode %s %s: %v", Battles, key, err)
+		}
+
+		if replay, ok := record["replay"]; ok {
+			if err := tx.Put(Replays, key, replay); err != nil {
+				return err
+			}
+			delete(record, "replay")
+			if err := tx.Put(Battles, key, record); err != nil {
+				return err
+			}
+		}
+		battle := ended{endedAt: summary.EndedAt, entry: BattleIndexEntry{BattleID: key}}
+		for _, player := range summary.Players {
+			battle.entry.Players = append(battle.entry.Players, player.Name)
+		}
+		battles = append(battles, battle)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(battles, func(i, j int) bool { return battles[i].endedAt.Before(battles[j].endedAt) })
+	for _, battle := range battles {
+		if err := AddToBattleIndex(tx, battle.entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchemaVersion is the schema version this program's migrations bring a
+// store to.
+func SchemaVersion() int {
+	return len(migrations)
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pokemon.db")
+	// A store at schema version 1, with replays inside the battle records
+	version1 := `{"version":1,"buckets":{
+		"players":{"sequence":0,"items":{}},
+		"caught":{"sequence":0,"items":{}},
+		"progress":{"sequence":0,"items":{}},
+		"ratings":{"sequence":0,"items":{}},
+		"rated_matches":{"sequence":0,"items":{}},
+		"battles":{"sequence":0,"items":{
+			"later":{"id":"later","ended_at":"2024-05-02T00:00:00Z","players":[{"name":"Ash"},{"name":"Gary"}],"replay":[1]},
+			"earlier":{"id":"earlier","ended_at":"2024-05-01T00:00:00Z","players":[{"name":"Misty"},{"name":"Brock"}],"replay":[2]}
+		}}
+	}}`
+	if err := os.WriteFile(path, []byte(version1), 0644); err != nil {
+		t.Fatalf("Failed to write store: %v", err)
+	}
+
+	store := openTestStore(t, path)
+	if store.Version() != SchemaVersion() {
+		t.Fatalf("Store is at version %d, want %d", store.Version(), SchemaVersion())
+	}
+	err := store.View(func(tx Tx) error {
+		var record map[string]interface{}
+		if err := tx.Get(Battles, "later", &record); err != nil {
+			return err
+		}
+		if _, ok := record["replay"]; ok {
+			t.Errorf("Battle record still holds its replay")
+		}
+		var replay []int
+		if err := tx.Get(Replays, "later", &replay); err != nil || len(replay) != 1 || replay[0] != 1 {
+			t.Errorf("Replay is %v, %v", replay, err)
+		}
+
+		var index []BattleIndexEntry
+		err := tx.ForEach(BattleIndex, "", func(key string, value json.RawMessage) error {
+			var entry BattleIndexEntry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return err
+			}
+			index = append(index, entry)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(index) != 2 || index[0].BattleID != "earlier" || index[1].BattleID != "later" {
+			t.Errorf("Battle index is %+v, want earlier then later", index)
+		} else if index[1].Players[0] != "Ash" || index[1].Players[1] != "Gary" {
+			t.Errorf("Battle index lists players %v", index[1].Players)
+		}
+
+		var value string
+		if err := tx.Get(Settings, "missing", &value); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Settings bucket lookup returned %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View failed: %v", err)
+	}
+
+	// Migrating again does nothing
+	if reopened := openTestStore(t, path); reopened.Version() != SchemaVersion() {
+		t.Errorf("Reopened store is at version %d", reopened.Version())
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pokemon.db")
+	if err := os.WriteFile(path, []byte(`{"version":1000,"buckets":{}}`), 0644); err != nil {
+		t.Fatalf("Failed to write store: %v", err)
+	}
+	if _, err := OpenFile(path); !errors.Is(err, ErrNewerVersion) {
+		t.Errorf("Opening a newer store returned %v", err)
+	}
+}
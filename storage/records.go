@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Player is a registered player name. PasswordHash lets the player log in
+// again for a new session; players registered before passwords have none.
+type Player struct {
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// BattleIndexEntry lists a finished battle in the battle index, with the
+// names of its players so a player's battles can be found without loading
+// every record.
+type BattleIndexEntry struct {
+	BattleID string   `json:"battle_id"`
+	Players  []string `json:"players"`
+}
+
+// CaughtPokemon is a Pokémon a player caught in the wild.
+type CaughtPokemon struct {
+	Number   uint64         `json:"number"` // in the order Pokémon were caught, by anyone
+	Player   string         `json:"player"`
+	Species  string         `json:"species"`
+	Region   string         `json:"region"`
+	Location string         `json:"location"`
+	Area     string         `json:"area"`
+	Sprite   string         `json:"sprite,omitempty"`
+	Stats    map[string]int `json:"stats"`
+	CaughtAt time.Time      `json:"caught_at"`
+}
+
+// PlayerKey builds the key of one of a player's records, such as a caught
+// Pokémon. The name is escaped so one player's prefix never matches another's.
+func PlayerKey(player, key string) string {
+	return PlayerPrefix(player) + key
+}
+
+// PlayerPrefix is the prefix shared by the keys of all a player's records.
+func PlayerPrefix(player string) string {
+	return url.PathEscape(player) + "/"
+}
+
+// SequenceKey turns a sequence number into a key that sorts in numeric order.
+func SequenceKey(n uint64) string {
+	return fmt.Sprintf("%020d", n)
+}
+
+// AddToBattleIndex adds a finished battle to the end of the battle index.
+func AddToBattleIndex(tx Tx, entry BattleIndexEntry) error {
+	n, err := tx.NextSequence(BattleIndex)
+	if err != nil {
+		return err
+	}
+	return tx.Put(BattleIndex, SequenceKey(n), entry)
+}
+
+// RegisterPlayer records a new player name with the hash of their password,
+// or returns ErrExists if it is taken.
+func RegisterPlayer(store Store, name, passwordHash string) error {
+	return store.Update(func(tx Tx) error {
+		var player Player
+		err := tx.Get(Players, name, &player)
+		if err == nil {
+			return fmt.Errorf("name %s: %w", name, ErrExists)
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return tx.Put(Players, name, Player{Name: name, PasswordHash: passwordHash, RegisteredAt: time.Now()})
+	})
+}
+
+// LookupPlayer finds a registered player, or returns ErrNotFound.
+func LookupPlayer(store Store, name string) (Player, error) {
+	var player Player
+	err := store.View(func(tx Tx) error {
+		return tx.Get(Players, name, &player)
+	})
+	return player, err
+}
+
+// AddCaught records a Pokémon the player caught, numbering it after the ones
+// they caught before.
+func AddCaught(store Store, caught *CaughtPokemon) error {
+	return store.Update(func(tx Tx) error {
+		n, err := tx.NextSequence(Caught)
+		if err != nil {
+			return err
+		}
+		caught.Number = n
+		return tx.Put(Caught, PlayerKey(caught.Player, SequenceKey(n)), caught)
+	})
+}
+
+// CaughtBy lists the Pokémon the player has caught, oldest first.
+func CaughtBy(store Store, player string) ([]CaughtPokemon, error) {
+	caught := []CaughtPokemon{}
+	err := store.View(func(tx Tx) error {
+		return tx.ForEach(Caught, PlayerPrefix(player), func(key string, value json.RawMessage) error {
+			var pokemon CaughtPokemon
+			if err := json.Unmarshal(value, &pokemon); err != nil {
+				return fmt.Errorf("failed to decode %s %s: %v", Caught, key, err)
+			}
+			caught = append(caught, pokemon)
+			return nil
+		})
+	})
+	return caught, err
+}
@@ -0,0 +1,81 @@
+// Package storage keeps the state the servers share between runs: players,
+// the Pokémon they have caught and trained, battle records and ratings.
+//
+// Records are kept in named buckets of keys, BoltDB style, and read and
+// written inside transactions. The schema is versioned and brought up to
+// date by migrations whenever a store is opened.
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// Buckets of the current schema.
+const (
+	Players      = "players"       // player name -> Player
+	Caught       = "caught"        // player name/catch number -> CaughtPokemon
+	Progress     = "progress"      // player name/roster key -> the battle server's trained Pokémon
+	Battles      = "battles"       // battle ID -> the battle server's battle record, without its replay
+	Replays      = "replays"       // battle ID -> the battle's replay
+	BattleIndex  = "battle_index"  // sequence -> BattleIndexEntry, in the order battles ended
+	Ratings      = "ratings"       // player name -> the battle server's rating
+	RatedMatches = "rated_matches" // sequence -> the battle server's rated match
+	Settings     = "settings"      // setting name -> value, such as the battle server's token secret
+)
+
+// DefaultPath is where the store lives unless POKEMON_DB says otherwise. Every
+// program runs from its own directory, so the store sits beside them all.
+const DefaultPath = "../pokemon.db"
+
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrExists       = errors.New("already exists")
+	ErrNoBucket     = errors.New("no such bucket")
+	ErrReadOnly     = errors.New("transaction is read-only")
+	ErrNewerVersion = errors.New("store was written by a newer schema version")
+
+	// Stop can be returned by a ForEach function to end the iteration early
+	// without an error.
+	Stop = errors.New("stop iteration")
+)
+
+// Store is a store of buckets, read and written in transactions.
+type Store interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(tx Tx) error) error
+	// Update runs fn in a read-write transaction. Its changes are saved
+	// only if fn returns nil.
+	Update(fn func(tx Tx) error) error
+	// Version returns the schema version the store is at.
+	Version() int
+	Close() error
+}
+
+// Tx reads and writes a store's buckets. Values are stored as JSON.
+type Tx interface {
+	// Get decodes the value under the key into v, or returns ErrNotFound.
+	Get(bucket, key string, v interface{}) error
+	Put(bucket, key string, v interface{}) error
+	Delete(bucket, key string) error
+	// ForEach calls fn for every key in the bucket starting with prefix, in
+	// key order, stopping at the first error.
+	ForEach(bucket, prefix string, fn func(key string, value json.RawMessage) error) error
+	// ForEachReverse is ForEach in reverse key order.
+	ForEachReverse(bucket, prefix string, fn func(key string, value json.RawMessage) error) error
+	// NextSequence returns the bucket's next sequence number, for keys that
+	// keep the order records were added in.
+	NextSequence(bucket string) (uint64, error)
+	CreateBucket(bucket string) error
+}
+
+// Open opens the store at POKEMON_DB, or DefaultPath, migrating it to the
+// current schema.
+func Open() (Store, error) {
+	path := os.Getenv("POKEMON_DB")
+	if path == "" {
+		path = DefaultPath
+	}
+	return OpenFile(path)
+}